@@ -0,0 +1,379 @@
+package SvgHelper
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A vertex of an svg path in canvas coordinates.
+type Vertex struct {
+	X int
+	Y int
+}
+
+// A shape on the canvas, kept as geometry instead of the pixels it covers.
+// Subpaths holds the vertices of every subpath of the svg path in drawing
+// order; a closed subpath repeats its first vertex at the end.
+type Shape struct {
+	ShapeHash   string
+	PublicKey   string
+	SvgString   string
+	Fill        string
+	StrokeWidth int
+	Subpaths    [][]Vertex
+}
+
+// returns true if the interior of the shape is part of the shape
+func (s Shape) filled() bool {
+	return !IsTransparent(s.Fill)
+}
+
+// returns true if the two svg paths parse to the same subpaths, whatever
+// commands they are drawn with, e.g. a rectangle path and the absolute path
+// MoveShapeInMap makes of it
+func SamePath(a string, b string) bool {
+	subpathsA, errA := parseSvgPath(a)
+	subpathsB, errB := parseSvgPath(b)
+	if errA != nil || errB != nil || len(subpathsA) != len(subpathsB) {
+		return false
+	}
+	for i := range subpathsA {
+		if len(subpathsA[i]) != len(subpathsB[i]) {
+			return false
+		}
+		for j := range subpathsA[i] {
+			if subpathsA[i][j] != subpathsB[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// parse svg path string into subpaths of vertices
+// supports M m L l H h V v Z z with integer arguments
+// - InvalidShapeSvgStringError: if the string can not be parsed
+func parseSvgPath(svgString string) (subpaths [][]Vertex, err error) {
+	tokens, err := tokenizeSvgPath(svgString)
+	if err != nil {
+		return nil, err
+	}
+	var current []Vertex
+	var cmd string
+	currentPoint := Vertex{0, 0}
+	initialPoint := Vertex{0, 0}
+	i := 0
+	for i < len(tokens) {
+		if isSvgCommand(tokens[i]) {
+			cmd = tokens[i]
+			i++
+			if cmd == "Z" || cmd == "z" {
+				if len(current) > 0 {
+					current = append(current, initialPoint)
+					subpaths = append(subpaths, current)
+					current = nil
+				}
+				currentPoint = initialPoint
+				continue
+			}
+		} else if cmd == "" || cmd == "Z" || cmd == "z" {
+			return nil, InvalidShapeSvgStringError(svgString)
+		}
+		// number of arguments this command takes
+		n := 2
+		if cmd == "H" || cmd == "h" || cmd == "V" || cmd == "v" {
+			n = 1
+		}
+		if i+n > len(tokens) {
+			return nil, InvalidShapeSvgStringError(svgString)
+		}
+		args := make([]int, n)
+		for k := 0; k < n; k++ {
+			num, err := strconv.Atoi(tokens[i+k])
+			if err != nil {
+				return nil, InvalidShapeSvgStringError(svgString)
+			}
+			args[k] = num
+		}
+		i += n
+		switch cmd {
+		case "M", "m":
+			if cmd == "M" {
+				currentPoint = Vertex{args[0], args[1]}
+			} else {
+				currentPoint = Vertex{currentPoint.X + args[0], currentPoint.Y + args[1]}
+			}
+			if len(current) > 1 {
+				subpaths = append(subpaths, current)
+			}
+			initialPoint = currentPoint
+			current = []Vertex{currentPoint}
+			// further coordinate pairs after a move are lines
+			if cmd == "M" {
+				cmd = "L"
+			} else {
+				cmd = "l"
+			}
+			continue
+		case "L":
+			currentPoint = Vertex{args[0], args[1]}
+		case "l":
+			currentPoint = Vertex{currentPoint.X + args[0], currentPoint.Y + args[1]}
+		case "H":
+			currentPoint.X = args[0]
+		case "h":
+			currentPoint.X += args[0]
+		case "V":
+			currentPoint.Y = args[0]
+		case "v":
+			currentPoint.Y += args[0]
+		}
+		if len(current) == 0 {
+			current = []Vertex{initialPoint}
+		}
+		current = append(current, currentPoint)
+	}
+	if len(current) > 1 {
+		subpaths = append(subpaths, current)
+	}
+	return subpaths, nil
+}
+
+// split svg path string into commands and numbers
+func tokenizeSvgPath(svgString string) ([]string, error) {
+	var tokens []string
+	number := ""
+	for i := 0; i < len(svgString); i++ {
+		c := svgString[i : i+1]
+		if (c >= "0" && c <= "9") || (c == "-" && number == "") {
+			number += c
+			continue
+		}
+		if number != "" {
+			tokens = append(tokens, number)
+			number = ""
+		}
+		if c == "-" {
+			number = c
+			continue
+		}
+		if isSvgCommand(c) {
+			tokens = append(tokens, c)
+		} else if strings.TrimSpace(c) != "" && c != "," {
+			return nil, InvalidShapeSvgStringError(svgString)
+		}
+	}
+	if number != "" {
+		tokens = append(tokens, number)
+	}
+	return tokens, nil
+}
+
+func isSvgCommand(s string) bool {
+	return len(s) == 1 && strings.Contains("MmLlHhVvZz", s)
+}
+
+// distance from the middle of a stroke to its edge, strokeWidth/2, and 0
+// for a thin line of width 1
+func halfStroke(strokeWidth int) float64 {
+	if strokeWidth <= 1 {
+		return 0
+	}
+	return float64(strokeWidth) / 2
+}
+
+// if two shapes share any point return true, else return false
+// outlines that touch or cross overlap, thick outlines overlap when their
+// edges come closer than the two half widths, and an outline (or part of
+// one) inside the interior of a filled shape overlaps it
+func shapesOverlap(a Shape, b Shape) bool {
+	reach := halfStroke(a.StrokeWidth) + halfStroke(b.StrokeWidth)
+	for _, pa := range a.Subpaths {
+		for _, pb := range b.Subpaths {
+			for i := 0; i+1 < len(pa); i++ {
+				for j := 0; j+1 < len(pb); j++ {
+					if segmentsIntersect(pa[i], pa[i+1], pb[j], pb[j+1]) {
+						return true
+					}
+					if reach > 0 && segmentsDistSq(pa[i], pa[i+1], pb[j], pb[j+1]) <= reach*reach {
+						return true
+					}
+				}
+			}
+		}
+	}
+	// outlines do not touch, so each subpath of one shape is either
+	// completely inside the other or completely outside, its first vertex
+	// decides it
+	return subpathInside(a, b) || subpathInside(b, a)
+}
+
+// if b is filled and any subpath of a starts inside it return true
+func subpathInside(a Shape, b Shape) bool {
+	if !b.filled() {
+		return false
+	}
+	for _, subpath := range a.Subpaths {
+		if len(subpath) > 0 && insidePolygon(subpath[0], b.Subpaths) {
+			return true
+		}
+	}
+	return false
+}
+
+// if segment p1-p2 and segment q1-q2 share any point return true
+func segmentsIntersect(p1 Vertex, p2 Vertex, q1 Vertex, q2 Vertex) bool {
+	d1 := orientation(q1, q2, p1)
+	d2 := orientation(q1, q2, p2)
+	d3 := orientation(p1, p2, q1)
+	d4 := orientation(p1, p2, q2)
+	if d1*d2 < 0 && d3*d4 < 0 {
+		return true
+	}
+	if d1 == 0 && onSegment(q1, q2, p1) {
+		return true
+	}
+	if d2 == 0 && onSegment(q1, q2, p2) {
+		return true
+	}
+	if d3 == 0 && onSegment(p1, p2, q1) {
+		return true
+	}
+	if d4 == 0 && onSegment(p1, p2, q2) {
+		return true
+	}
+	return false
+}
+
+// find two edges of the closed subpaths that cross or touch each other
+// other than at the vertex joining neighbouring edges, edges of zero
+// length are ignored
+// - InvalidShapeSvgStringError: naming the two edges if any are found
+func checkSelfIntersection(svgString string, subpaths [][]Vertex) error {
+	var edges [][2]Vertex
+	var first []int // index of the first edge of the ring of each edge
+	for _, subpath := range subpaths {
+		start := len(edges)
+		for i := 0; i+1 < len(subpath); i++ {
+			if subpath[i] != subpath[i+1] {
+				edges = append(edges, [2]Vertex{subpath[i], subpath[i+1]})
+				first = append(first, start)
+			}
+		}
+	}
+	for i := range edges {
+		for j := i + 1; j < len(edges); j++ {
+			a, b := edges[i], edges[j]
+			var crossing bool
+			if first[i] == first[j] && j == i+1 {
+				crossing = doublesBack(a[0], a[1], b[1])
+			} else if first[i] == first[j] && i == first[i] && (j+1 == len(edges) || first[j+1] != first[j]) {
+				// last and first edge of the ring
+				crossing = doublesBack(b[0], b[1], a[1])
+			} else {
+				crossing = segmentsIntersect(a[0], a[1], b[0], b[1])
+			}
+			if crossing {
+				return InvalidShapeSvgStringError(fmt.Sprintf("%s: edge %s crosses edge %s",
+					svgString, edgeString(a), edgeString(b)))
+			}
+		}
+	}
+	return nil
+}
+
+// for neighbouring edges a-b and b-c, returns true if b-c runs back
+// along a-b so the two edges share more than the vertex b
+func doublesBack(a Vertex, b Vertex, c Vertex) bool {
+	if orientation(a, b, c) != 0 {
+		return false
+	}
+	dot := int64(a.X-b.X)*int64(c.X-b.X) + int64(a.Y-b.Y)*int64(c.Y-b.Y)
+	return dot > 0
+}
+
+func edgeString(e [2]Vertex) string {
+	return fmt.Sprintf("(%d,%d)-(%d,%d)", e[0].X, e[0].Y, e[1].X, e[1].Y)
+}
+
+// squared distance between two segments that do not intersect
+func segmentsDistSq(p1 Vertex, p2 Vertex, q1 Vertex, q2 Vertex) float64 {
+	d := pointSegmentDistSq(p1, q1, q2)
+	d = math.Min(d, pointSegmentDistSq(p2, q1, q2))
+	d = math.Min(d, pointSegmentDistSq(q1, p1, p2))
+	d = math.Min(d, pointSegmentDistSq(q2, p1, p2))
+	return d
+}
+
+// squared distance from point p to segment a-b
+func pointSegmentDistSq(p Vertex, a Vertex, b Vertex) float64 {
+	dx := float64(b.X - a.X)
+	dy := float64(b.Y - a.Y)
+	px := float64(p.X - a.X)
+	py := float64(p.Y - a.Y)
+	lenSq := dx*dx + dy*dy
+	t := 0.0
+	if lenSq > 0 {
+		t = math.Max(0, math.Min(1, (px*dx+py*dy)/lenSq))
+	}
+	ex := px - t*dx
+	ey := py - t*dy
+	return ex*ex + ey*ey
+}
+
+// sign of the cross product (b - a) x (c - a):
+// 1 counter clockwise, -1 clockwise, 0 collinear
+func orientation(a Vertex, b Vertex, c Vertex) int {
+	cross := int64(b.X-a.X)*int64(c.Y-a.Y) - int64(b.Y-a.Y)*int64(c.X-a.X)
+	if cross > 0 {
+		return 1
+	}
+	if cross < 0 {
+		return -1
+	}
+	return 0
+}
+
+// given c collinear with a-b, returns true if c lies on the segment a-b
+func onSegment(a Vertex, b Vertex, c Vertex) bool {
+	return c.X >= minInt(a.X, b.X) && c.X <= maxInt(a.X, b.X) &&
+		c.Y >= minInt(a.Y, b.Y) && c.Y <= maxInt(a.Y, b.Y)
+}
+
+// even-odd test of whether point p is strictly inside the polygon made of
+// the given subpaths, each subpath is treated as a closed ring
+func insidePolygon(p Vertex, subpaths [][]Vertex) bool {
+	inside := false
+	for _, ring := range subpaths {
+		n := len(ring)
+		for i := 0; i < n; i++ {
+			a := ring[i]
+			b := ring[(i+1)%n]
+			if (a.Y > p.Y) != (b.Y > p.Y) {
+				// x of the edge at height p.Y compared without division
+				lhs := int64(p.X-a.X) * int64(b.Y-a.Y)
+				rhs := int64(b.X-a.X) * int64(p.Y-a.Y)
+				if (b.Y > a.Y && lhs < rhs) || (b.Y < a.Y && lhs > rhs) {
+					inside = !inside
+				}
+			}
+		}
+	}
+	return inside
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,171 @@
+package SvgHelper
+
+import (
+	"math"
+)
+
+// An axis aligned rectangle, bounds are inclusive.
+type Rect struct {
+	MinX int
+	MinY int
+	MaxX int
+	MaxY int
+}
+
+// returns true if the two rectangles share any point
+func (r Rect) intersects(o Rect) bool {
+	return r.MinX <= o.MaxX && o.MinX <= r.MaxX && r.MinY <= o.MaxY && o.MinY <= r.MaxY
+}
+
+// returns true if o lies completely inside r
+func (r Rect) contains(o Rect) bool {
+	return r.MinX <= o.MinX && o.MaxX <= r.MaxX && r.MinY <= o.MinY && o.MaxY <= r.MaxY
+}
+
+// bounding box of all vertices of the shape, grown by half the stroke width
+func (s Shape) bounds() Rect {
+	first := true
+	var r Rect
+	for _, subpath := range s.Subpaths {
+		for _, v := range subpath {
+			if first {
+				r = Rect{v.X, v.Y, v.X, v.Y}
+				first = false
+				continue
+			}
+			r.MinX = minInt(r.MinX, v.X)
+			r.MinY = minInt(r.MinY, v.Y)
+			r.MaxX = maxInt(r.MaxX, v.X)
+			r.MaxY = maxInt(r.MaxY, v.Y)
+		}
+	}
+	grow := int(math.Ceil(halfStroke(s.StrokeWidth)))
+	return Rect{r.MinX - grow, r.MinY - grow, r.MaxX + grow, r.MaxY + grow}
+}
+
+const (
+	// number of shapes a quadtree node holds before it is split
+	quadCapacity = 8
+	// nodes at this depth are never split
+	quadMaxDepth = 10
+)
+
+// Spatial index over the bounding boxes of the shapes on a canvas.
+// It is a quadtree: a shape is kept in the smallest node that fully
+// contains its bounds. The root covers the canvas, shapes reaching outside
+// of it stay in the root.
+// The index lives next to the map of shapes it was built from and has to
+// be updated on every add and remove to that map.
+type ShapeIndex struct {
+	root  *quadNode
+	rects map[string]Rect // shape hash to bounds of the shape
+}
+
+type quadNode struct {
+	bounds   Rect
+	depth    int
+	items    map[string]Rect
+	children []*quadNode
+}
+
+// build an index over all shapes in the map, on a canvas of the given size
+func NewShapeIndex(shapes map[string]Shape, canvas CanvasSettings) *ShapeIndex {
+	area := Rect{0, 0, int(canvas.CanvasXMax) - 1, int(canvas.CanvasYMax) - 1}
+	index := &ShapeIndex{
+		root:  newQuadNode(area, 0),
+		rects: make(map[string]Rect),
+	}
+	for _, shape := range shapes {
+		index.Insert(shape)
+	}
+	return index
+}
+
+// add shape to the index, replacing any earlier entry with the same hash
+func (index *ShapeIndex) Insert(shape Shape) {
+	index.Remove(shape.ShapeHash)
+	r := shape.bounds()
+	index.rects[shape.ShapeHash] = r
+	index.root.insert(shape.ShapeHash, r)
+}
+
+// remove shape with the given hash from the index
+func (index *ShapeIndex) Remove(shapeHash string) {
+	r, exist := index.rects[shapeHash]
+	if !exist {
+		return
+	}
+	delete(index.rects, shapeHash)
+	index.root.remove(shapeHash, r)
+}
+
+// return hashes of all shapes whose bounds intersect r
+func (index *ShapeIndex) Query(r Rect) []string {
+	var hashes []string
+	index.root.query(r, &hashes)
+	return hashes
+}
+
+func newQuadNode(bounds Rect, depth int) *quadNode {
+	return &quadNode{bounds: bounds, depth: depth, items: make(map[string]Rect)}
+}
+
+// the child that fully contains r, nil if there is none
+func (n *quadNode) childFor(r Rect) *quadNode {
+	for _, child := range n.children {
+		if child.bounds.contains(r) {
+			return child
+		}
+	}
+	return nil
+}
+
+func (n *quadNode) insert(shapeHash string, r Rect) {
+	if child := n.childFor(r); child != nil {
+		child.insert(shapeHash, r)
+		return
+	}
+	n.items[shapeHash] = r
+	if n.children == nil && len(n.items) > quadCapacity && n.depth < quadMaxDepth {
+		n.split()
+	}
+}
+
+// create the four children and push down every item that fits in one
+func (n *quadNode) split() {
+	midX := (n.bounds.MinX + n.bounds.MaxX) / 2
+	midY := (n.bounds.MinY + n.bounds.MaxY) / 2
+	n.children = []*quadNode{
+		newQuadNode(Rect{n.bounds.MinX, n.bounds.MinY, midX, midY}, n.depth+1),
+		newQuadNode(Rect{midX + 1, n.bounds.MinY, n.bounds.MaxX, midY}, n.depth+1),
+		newQuadNode(Rect{n.bounds.MinX, midY + 1, midX, n.bounds.MaxY}, n.depth+1),
+		newQuadNode(Rect{midX + 1, midY + 1, n.bounds.MaxX, n.bounds.MaxY}, n.depth+1),
+	}
+	for shapeHash, r := range n.items {
+		if child := n.childFor(r); child != nil {
+			delete(n.items, shapeHash)
+			child.insert(shapeHash, r)
+		}
+	}
+}
+
+func (n *quadNode) remove(shapeHash string, r Rect) {
+	if child := n.childFor(r); child != nil {
+		child.remove(shapeHash, r)
+		return
+	}
+	delete(n.items, shapeHash)
+}
+
+func (n *quadNode) query(r Rect, hashes *[]string) {
+	for shapeHash, itemRect := range n.items {
+		if itemRect.intersects(r) {
+			*hashes = append(*hashes, shapeHash)
+		}
+	}
+	for _, child := range n.children {
+		if child.bounds.intersects(r) {
+			child.query(r, hashes)
+		}
+	}
+}
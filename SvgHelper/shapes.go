@@ -0,0 +1,160 @@
+package SvgHelper
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// most vertices a polygon or polyline can have
+const maxShapePoints = 64
+
+// Shape types of ShapeElement, the values of ShapeType in blockartlib and
+// the miner.
+const (
+	PathShape = iota
+	RectShape
+	EllipseShape
+	PolygonShape
+	PolylineShape
+)
+
+// Parameters of the shapes that are not given as an svg path.
+// Only the fields of the shape being drawn are used.
+type ShapeParams struct {
+	// rectangle: top left corner, width and height
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// ellipse: centre and the two radii
+	Cx int
+	Cy int
+	Rx int
+	Ry int
+	// polygon and polyline: vertices in drawing order
+	Points []Vertex
+}
+
+// svg path with the same outline as the rectangle
+// - InvalidShapeSvgStringError: if width or height is not positive
+func RectPath(params ShapeParams) (string, error) {
+	if params.Width <= 0 || params.Height <= 0 {
+		return "", InvalidShapeSvgStringError("rectangle needs a positive width and height")
+	}
+	return "M " + pairString(params.X, params.Y) + " h " + strconv.Itoa(params.Width) +
+		" v " + strconv.Itoa(params.Height) + " h " + strconv.Itoa(-params.Width) + " z", nil
+}
+
+// svg path of a polygon approximating the ellipse, the vertices are points
+// of the ellipse rounded to the pixel grid, more of them for larger ellipses
+// - InvalidShapeSvgStringError: if a radius is not positive
+func EllipsePath(params ShapeParams) (string, error) {
+	if params.Rx <= 0 || params.Ry <= 0 {
+		return "", InvalidShapeSvgStringError("ellipse needs positive radii")
+	}
+	// a multiple of 4 so the extreme points are always vertices
+	n := 4 * minInt(maxInt((maxInt(params.Rx, params.Ry)+1)/2, 2), maxShapePoints/4)
+	var points []Vertex
+	for k := 0; k < n; k++ {
+		angle := 2 * math.Pi * float64(k) / float64(n)
+		v := Vertex{params.Cx + int(math.Round(float64(params.Rx)*math.Cos(angle))),
+			params.Cy + int(math.Round(float64(params.Ry)*math.Sin(angle)))}
+		if len(points) == 0 || points[len(points)-1] != v {
+			points = append(points, v)
+		}
+	}
+	return pointsPath(points, true), nil
+}
+
+// svg path through the points, closed back to the first point
+// - InvalidShapeSvgStringError: if there are less than 3 points
+// - ShapeSvgStringTooLongError: if there are more than 64 points
+func PolygonPath(params ShapeParams) (string, error) {
+	if len(params.Points) < 3 {
+		return "", InvalidShapeSvgStringError("polygon needs at least 3 points")
+	}
+	if len(params.Points) > maxShapePoints {
+		return "", ShapeSvgStringTooLongError("polygon has more than 64 points")
+	}
+	return pointsPath(params.Points, true), nil
+}
+
+// svg path through the points, left open
+// - InvalidShapeSvgStringError: if there are less than 2 points
+// - ShapeSvgStringTooLongError: if there are more than 64 points
+func PolylinePath(params ShapeParams) (string, error) {
+	if len(params.Points) < 2 {
+		return "", InvalidShapeSvgStringError("polyline needs at least 2 points")
+	}
+	if len(params.Points) > maxShapePoints {
+		return "", ShapeSvgStringTooLongError("polyline has more than 64 points")
+	}
+	return pointsPath(params.Points, false), nil
+}
+
+// svg path with the geometry of the shape and the svg element the shape is
+// drawn with. Paths are drawn as <path>, the other shape types as their own
+// svg element. Fill and stroke must be CSS colours so they can not break
+// out of their attributes.
+// - InvalidColourError: if fill or stroke is not a colour
+// - the errors of RectPath, EllipsePath, PolygonPath and PolylinePath
+// - InvalidShapeSvgStringError: if the shape type is unknown
+func ShapeElement(shapeType int, svgString string, p ShapeParams, fill string, stroke string, strokeWidth int) (path string, element string, err error) {
+	if err := ValidateColour(fill); err != nil {
+		return "", "", err
+	}
+	if err := ValidateColour(stroke); err != nil {
+		return "", "", err
+	}
+	switch shapeType {
+	case PathShape:
+		path = svgString
+		element = "<path d=\"" + path + "\""
+	case RectShape:
+		path, err = RectPath(p)
+		element = fmt.Sprintf("<rect x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\"", p.X, p.Y, p.Width, p.Height)
+	case EllipseShape:
+		path, err = EllipsePath(p)
+		element = fmt.Sprintf("<ellipse cx=\"%d\" cy=\"%d\" rx=\"%d\" ry=\"%d\"", p.Cx, p.Cy, p.Rx, p.Ry)
+	case PolygonShape:
+		path, err = PolygonPath(p)
+		element = "<polygon points=\"" + PointsAttribute(p.Points) + "\""
+	case PolylineShape:
+		path, err = PolylinePath(p)
+		element = "<polyline points=\"" + PointsAttribute(p.Points) + "\""
+	default:
+		err = InvalidShapeSvgStringError("unknown shape type " + strconv.Itoa(shapeType))
+	}
+	if err != nil {
+		return "", "", err
+	}
+	element += " stroke=\"" + stroke + "\" stroke-width=\"" + strconv.Itoa(strokeWidth) +
+		"\" fill=\"" + fill + "\"/>"
+	return path, element, nil
+}
+
+// the points as the value of the points attribute of <polygon> and <polyline>
+func PointsAttribute(points []Vertex) string {
+	pairs := make([]string, len(points))
+	for i, p := range points {
+		pairs[i] = strconv.Itoa(p.X) + "," + strconv.Itoa(p.Y)
+	}
+	return strings.Join(pairs, " ")
+}
+
+func pointsPath(points []Vertex, closed bool) string {
+	path := "M " + pairString(points[0].X, points[0].Y)
+	for _, p := range points[1:] {
+		path += " L " + pairString(p.X, p.Y)
+	}
+	if closed {
+		path += " z"
+	}
+	return path
+}
+
+func pairString(x int, y int) string {
+	return strconv.Itoa(x) + " " + strconv.Itoa(y)
+}
@@ -3,22 +3,22 @@ package SvgHelper
 import (
 	"fmt"
 	"math"
-	"regexp"
-	"strconv"
+	"sort"
+	"strings"
 )
 
-// var mapPoints map[string]MapPoint
-
-type MapPoint struct {
-	Count     int
-	PublicKey string
-}
-
 type point struct {
 	x int
 	y int
 }
 
+// Settings for a canvas in BlockArt.
+type CanvasSettings struct {
+	// Canvas dimensions
+	CanvasXMax uint32
+	CanvasYMax uint32
+}
+
 // Contains amount of ink remaining.
 type InsufficientInkError uint32
 
@@ -26,11 +26,14 @@ func (e InsufficientInkError) Error() string {
 	return fmt.Sprintf("BlockArt: Not enough ink to addShape [%d]", uint32(e))
 }
 
-// Empty
-type OutOfBoundsError struct{}
+// Contains the coordinate that is outside the canvas.
+type OutOfBoundsError struct {
+	X int
+	Y int
+}
 
 func (e OutOfBoundsError) Error() string {
-	return fmt.Sprintf("BlockArt: Shape is outside the bounds of the canvas")
+	return fmt.Sprintf("BlockArt: Shape is outside the bounds of the canvas [%d,%d]", e.X, e.Y)
 }
 
 // Contains the hash of the shape that this shape overlaps with.
@@ -62,1033 +65,527 @@ func (e ShapeSvgStringTooLongError) Error() string {
 }
 
 //------------------------------------------------------------------------------------------------
-// add shape to map struct mapPoints
+// add shape to map of shapes on the canvas
 // args:
+// - shapeHash : key of the shape in shapes
 // - svgString : passed from client
 // - shapType : fill or transparent
+// - strokeWidth : width of the outline in pixels, 1 for a thin line
 // - minerInk : currrent ink miner has
+// - canvas : canvas settings of the network
+// - index : spatial index over shapes, only shapes with intersecting
+//           bounds are checked for overlap. If nil all shapes are checked
 /////////////////
 // Can return the following errors:
-// - DisconnectedError
-// - ShapeOverlapError
-// - ShapeOwnerError
+// - ShapeOverlapError: if shape overlaps a shape of another owner
 // - OutofBoundError: if any point is outside canvas size, return error
-// - InsufficientInkError: if given minerInk is less then ink needed
-// - InvalidShapeSvgStringError: if given filled type with not closed shape
-func AddShapeToMap(svgString string, publicKey string, shapeType string, minerInk int, mapPoints map[string]MapPoint) (ink int, err error) {
-	var transparentMapPoints map[int]point
-	var polygon [][]bool
-	var close bool
-	if shapeType == "transparent" {
-		transparentMapPoints, ink, _, err = TransparentSvgToCoord(svgString, publicKey, minerInk)
-		if err != nil {
-			fmt.Println(err)
-			return 0, err
-		}
-		//check overlap
-		for _, value := range transparentMapPoints {
-			overlap := checkOverlap(value, publicKey, mapPoints)
-			if overlap {
-				pString := strconv.Itoa(value.x) + "," + strconv.Itoa(value.y)
-				err = ShapeOverlapError(pString)
-				fmt.Println(err)
-				return 0, err
-			}
-		}
-		// if no overlap add all points in map
-		for _, value := range transparentMapPoints {
-			addPoint(value, publicKey, mapPoints)
-		}
-		// filled
-	} else {
-		transparentMapPoints, _, close, err = TransparentSvgToCoord(svgString, publicKey, minerInk)
-		if !close {
-			err = InvalidShapeSvgStringError(svgString)
-			fmt.Println(err)
-			return 0, err
-		}
-		polygon, ink, err = FilledSvgToPolygon(transparentMapPoints, publicKey, minerInk)
-		if err != nil {
-			fmt.Println(err)
-			return 0, err
-		}
-		//check overlap
-		for j := range polygon {
-			for k := range polygon[j] {
-				if polygon[j][k] {
-					value := point{x: k, y: j}
-					overlap := checkOverlap(value, publicKey, mapPoints)
-					if overlap {
-						pString := strconv.Itoa(value.x) + "," + strconv.Itoa(value.y)
-						err = ShapeOverlapError(pString)
-						fmt.Println(err)
-						return 0, err
-					}
-				}
-
-			}
-		}
-		// if no overlap add all points in map
-		for j := range polygon {
-			for k := range polygon[j] {
-				if polygon[j][k] {
-					value := point{x: k, y: j}
-					addPoint(value, publicKey, mapPoints)
-				}
-			}
-		}
+// - InsufficientInkError: with minerInk, if given minerInk is less then ink needed
+// - InvalidShapeSvgStringError: if given filled type with not closed or self-intersecting shape
+func AddShapeToMap(shapeHash string, svgString string, publicKey string, shapeType string, strokeWidth int, minerInk int, canvas CanvasSettings, shapes map[string]Shape, index *ShapeIndex) (ink int, err error) {
+	subpaths, pixels, err := shapePixels(svgString, shapeType, strokeWidth, canvas)
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
+	}
+	ink = len(pixels)
+	if ink > minerInk {
+		err = InsufficientInkError(minerInk)
+		fmt.Println(err)
+		return 0, err
+	}
+	shape := Shape{ShapeHash: shapeHash, PublicKey: publicKey, SvgString: svgString, Fill: shapeType,
+		StrokeWidth: strokeWidth, Subpaths: subpaths}
+	//check overlap
+	if hash := firstOverlap(shape, shapes, index); hash != "" {
+		err = ShapeOverlapError(hash)
+		fmt.Println(err)
+		return 0, err
+	}
+	// if no overlap add shape in map
+	shapes[shapeHash] = shape
+	if index != nil {
+		index.Insert(shape)
 	}
-	// for key, value := range mapPoints {
-	// 	fmt.Printf("in glogal mapPoints, key %s, count %d, publickey %s\n", key, value.count, value.publicKey)
-	// }
 	return ink, nil
 }
 
-// remove shape from map struct mapPoints, return ink returned
+// add a batch of shapes to map of shapes on the canvas, all of them or none
+// of them, return the ink of the whole batch
 // args:
-// - svgString : passed from client
-// - shapType : fill or transparent
+// - batch : shapes to add with ShapeHash, PublicKey, SvgString, Fill and
+//           StrokeWidth set, Subpaths is filled in
+// - minerInk : currrent ink miner has, for the whole batch
+// - canvas : canvas settings of the network
+// - index : spatial index over shapes, may be nil
 /////////////////
 // Can return the following errors:
-// - DisconnectedError
-// - ShapeOwnerError
-// - OutofBoundError: if any point is outside canvas size, return error
-// - InvalidShapeSvgStringError: if given filled type with not closed shape
-func RemoveShapeFromMap(svgString string, publicKey string, shapeType string, mapPoints map[string]MapPoint) (ink int, err error) {
-	var transparentMapPoints map[int]point
-	var polygon [][]bool
-	var close bool
-	//var close bool
-	if shapeType == "transparent" {
-		transparentMapPoints, ink, _, err = RemoveTransparentSvgToCoord(svgString, publicKey)
+// - ShapeOverlapError: if a shape overlaps a shape of another owner, or any
+//   other shape of the batch
+// - OutofBoundError: if any point is outside canvas size
+// - InsufficientInkError: with minerInk, if given minerInk is less then ink of the batch
+// - InvalidShapeSvgStringError: if a shape can not be parsed, or is filled
+//   and not closed or self-intersecting
+func AddShapesToMap(batch []Shape, minerInk int, canvas CanvasSettings, shapes map[string]Shape, index *ShapeIndex) (ink int, err error) {
+	for i := range batch {
+		subpaths, pixels, err := shapePixels(batch[i].SvgString, batch[i].Fill, batch[i].StrokeWidth, canvas)
 		if err != nil {
 			fmt.Println(err)
 			return 0, err
 		}
-		//check have all points to remove
-		for _, value := range transparentMapPoints {
-			err = havePoint(value, publicKey, mapPoints)
-			if err != nil {
-				fmt.Println(err)
-				return 0, err
-			}
-		}
-		//if have all points, remove points from global map
-		for _, value := range transparentMapPoints {
-			removePoint(value, publicKey, mapPoints)
-		}
-		// filled
-	} else {
-		transparentMapPoints, _, close, err = RemoveTransparentSvgToCoord(svgString, publicKey)
-		if !close {
-			err = InvalidShapeSvgStringError(svgString)
-			fmt.Println(err)
-			return 0, err
-		}
-		polygon, ink, err = RemoveFilledSvgToPolygon(transparentMapPoints, publicKey)
-		if err != nil {
+		batch[i].Subpaths = subpaths
+		ink += len(pixels)
+	}
+	if ink > minerInk {
+		err = InsufficientInkError(minerInk)
+		fmt.Println(err)
+		return 0, err
+	}
+	for i, shape := range batch {
+		if hash := firstOverlap(shape, shapes, index); hash != "" {
+			err = ShapeOverlapError(hash)
 			fmt.Println(err)
 			return 0, err
 		}
-		//check have all points to remove
-		for j := range polygon {
-			for k := range polygon[j] {
-				if polygon[j][k] {
-					value := point{x: k, y: j}
-					err = havePoint(value, publicKey, mapPoints)
-					if err != nil {
-						fmt.Println(err)
-						return 0, err
-					}
-				}
+		// pieces of a batch may not overlap even with the same owner
+		for _, other := range batch[:i] {
+			if shape.ShapeHash == other.ShapeHash || shapesOverlap(shape, other) {
+				err = ShapeOverlapError(other.ShapeHash)
+				fmt.Println(err)
+				return 0, err
 			}
 		}
-		//if have all points, remove points from global map
-		for j := range polygon {
-			for k := range polygon[j] {
-				if polygon[j][k] {
-					value := point{x: k, y: j}
-					removePoint(value, publicKey, mapPoints)
-				}
-			}
+	}
+	for _, shape := range batch {
+		shapes[shape.ShapeHash] = shape
+		if index != nil {
+			index.Insert(shape)
 		}
 	}
-	// for key, value := range mapPoints {
-	// 	fmt.Printf("in glogal mapPoints, key %s, count %d\n", key, value.count)
-	// }
 	return ink, nil
 }
 
-// this helper function convert from svg string to coordinates in the map
-// return :
-// localMapPoints map[int]point: Map of Points of this svg
-// ink int: needed to draw such svgpath
-// close bool: if svg is closed or not
-// err error:
-// 1: OutofBoundError: if any point is outside canvas size, return error
-// 2: InsufficientInkError: if given minerInk is less then ink needed
-func TransparentSvgToCoord(svgString string, publicKey string, minerInk int) (localMapPoints map[int]point, ink int, close bool, err error) {
-	initialPoint := point{x: 0, y: 0}
-	endPoint := point{x: 0, y: 0}
-	currentPoint := point{x: 0, y: 0}
-	var temPoint point
-	var p point
-	var j int
-	var s3 string
-	var points []point
-	ink = 0
-	close = false
-	localMapPoints = make(map[int]point)
-	index := 0
-	i := 0
-	for i < len(svgString) {
-		s := string(svgString[i : i+1])
-		//println(s)
-		if s == "M" || s == "m" {
-			s2 := s
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
+// ink needed to draw the shape, args are the same as for AddShapeToMap
+// Can return the following errors:
+// - InvalidShapeSvgStringError, OutofBoundError: as for AddShapeToMap
+func InkCost(svgString string, shapeType string, strokeWidth int, canvas CanvasSettings) (ink int, err error) {
+	_, pixels, err := shapePixels(svgString, shapeType, strokeWidth, canvas)
+	if err != nil {
+		return 0, err
+	}
+	return len(pixels), nil
+}
 
-			}
-			// parse x
-			if isNumber {
-				for isNumber {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "M" {
-					initialPoint.x = num
-					currentPoint.x = num
-				} else {
-					initialPoint.x = num + initialPoint.x
-					currentPoint.x = num + currentPoint.x
-				}
-				// println("x1")
-				// println(initialPoint.x)
-				s3 = ""
-			}
-			for s == " " {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "M" {
-					initialPoint.y = num
-					currentPoint.y = num
-				} else {
-					initialPoint.y = num + initialPoint.y
-					currentPoint.y = num + currentPoint.y
-				}
-				if !checkCanvasSize(currentPoint) {
-					err = OutOfBoundsError{}
-					return localMapPoints, ink, close, err
-				}
-				// println("y1")
-				// println(initialPoint.y)
-				s3 = ""
-			}
-		}
-		if s == "L" || s == "l" {
-			s2 := s
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse x
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "L" {
-					temPoint.x = num
-				} else {
-					temPoint.x = num + currentPoint.x
-				}
-				// println("x3")
-				// println(temPoint.x)
-				s3 = ""
-			}
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "L" {
-					temPoint.y = num
-				} else {
-					temPoint.y = num + currentPoint.y
-				}
-				// println("y3")
-				// println(temPoint.y)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in L, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in L's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
+// pixels of the outline and of the interior of the shape, each sorted by y
+// then x. The interior is empty for transparent shapes. These are the
+// pixels the ink of the shape pays for.
+// Can return the following errors:
+// - InvalidShapeSvgStringError, OutofBoundError: as for AddShapeToMap
+func ShapePixels(svgString string, shapeType string, strokeWidth int, canvas CanvasSettings) (outline []Vertex, interior []Vertex, err error) {
+	_, all, err := shapePixels(svgString, shapeType, strokeWidth, canvas)
+	if err != nil {
+		return nil, nil, err
+	}
+	_, line, err := shapePixels(svgString, "transparent", strokeWidth, canvas)
+	if err != nil {
+		return nil, nil, err
+	}
+	for p := range all {
+		if line[p] {
+			outline = append(outline, Vertex{p.x, p.y})
+		} else {
+			interior = append(interior, Vertex{p.x, p.y})
 		}
-		if s == "H" || s == "h" {
-			s2 := s
-			temPoint.y = currentPoint.y
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse x
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "H" {
-					temPoint.x = num
-				} else {
-					temPoint.x = num + currentPoint.x
-				}
-				// println("x3")
-				// println(temPoint.x)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in H, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in H's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
+	}
+	sortVertices(outline)
+	sortVertices(interior)
+	return outline, interior, nil
+}
+
+func sortVertices(vertices []Vertex) {
+	sort.Slice(vertices, func(i, j int) bool {
+		if vertices[i].Y != vertices[j].Y {
+			return vertices[i].Y < vertices[j].Y
 		}
-		if s == "V" || s == "v" {
-			s2 := s
-			temPoint.x = currentPoint.x
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "V" {
-					temPoint.y = num
-				} else {
-					temPoint.y = num + currentPoint.y
-				}
-				// println("y3")
-				// println(num)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in V, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in V's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
+		return vertices[i].X < vertices[j].X
+	})
+}
+
+// Result of checking a shape against the canvas without adding it.
+type ShapeEstimate struct {
+	// Ink needed to draw the shape, 0 if it is not in bounds
+	InkCost uint32
+	// If every pixel of the shape is on the canvas
+	InBounds bool
+	// Hash of the first shape of another owner it overlaps, "" if none
+	OverlapHash string
+}
+
+// dry run of AddShapeToMap: computes what adding the shape would cost and
+// what it would run into, without changing shapes or index
+// args are the same as for AddShapeToMap
+/////////////////
+// Can return the following errors:
+// - InvalidShapeSvgStringError: if svg can not be parsed, or filled shape is
+//   not closed or has edges crossing each other
+func EstimateShape(svgString string, publicKey string, shapeType string, strokeWidth int, canvas CanvasSettings, shapes map[string]Shape, index *ShapeIndex) (estimate ShapeEstimate, err error) {
+	subpaths, pixels, err := shapePixels(svgString, shapeType, strokeWidth, canvas)
+	if _, ok := err.(OutOfBoundsError); ok {
+		// still report overlap of the part that is on the canvas
+		subpaths, err = parseSvgPath(svgString)
+	} else if err == nil {
+		estimate.InkCost = uint32(len(pixels))
+		estimate.InBounds = true
+	}
+	if err != nil {
+		return ShapeEstimate{}, err
+	}
+	shape := Shape{PublicKey: publicKey, SvgString: svgString, Fill: shapeType,
+		StrokeWidth: strokeWidth, Subpaths: subpaths}
+	estimate.OverlapHash = firstOverlap(shape, shapes, index)
+	return estimate, nil
+}
+
+// hash of the first shape (in hash order) of another owner that the
+// shape overlaps, "" if there is none
+func firstOverlap(shape Shape, shapes map[string]Shape, index *ShapeIndex) string {
+	var candidates []string
+	if index != nil {
+		candidates = index.Query(shape.bounds())
+	} else {
+		for hash := range shapes {
+			candidates = append(candidates, hash)
 		}
-		if s == "Z" || s == "z" {
-			temPoint.x = initialPoint.x
-			temPoint.y = initialPoint.y
-			i++
-			// parse string before next letter
-			for s == " " && len(svgString) > i {
-				i++
-				if len(svgString) > i {
-					s = string(svgString[i : i+1])
-				}
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in Z, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			// remove the last point because its the same as very first vertex
-			points = points[:len(points)-1]
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			currentPoint = temPoint
-			endPoint = temPoint
+	}
+	sort.Strings(candidates)
+	for _, hash := range candidates {
+		other := shapes[hash]
+		if other.PublicKey != shape.PublicKey && shapesOverlap(shape, other) {
+			return hash
 		}
-		i++
 	}
-	if endPoint == initialPoint {
-		close = true
+	return ""
+}
+
+// remove shape from map of shapes on the canvas, return ink returned
+// args:
+// - shapeHash : key of the shape in shapes
+// - publicKey : key of the art node removing the shape
+// - canvas : canvas settings of the network
+// - index : spatial index over shapes, may be nil
+/////////////////
+// Can return the following errors:
+// - ShapeOwnerError: if shape is not on canvas or owned by someone else
+func RemoveShapeFromMap(shapeHash string, publicKey string, canvas CanvasSettings, shapes map[string]Shape, index *ShapeIndex) (ink int, err error) {
+	shape, exist := shapes[shapeHash]
+	if !exist || shape.PublicKey != publicKey {
+		err = ShapeOwnerError(shapeHash)
+		fmt.Println(err)
+		return 0, err
 	}
-	for _, _ = range localMapPoints {
-		//fmt.Printf("in localMapPoints, key %d, pstring %s\n", key, value)
-		ink++
+	// same pixels as when the shape was added, so the refund matches the cost
+	_, pixels, err := shapePixels(shape.SvgString, shape.Fill, shape.StrokeWidth, canvas)
+	if err != nil {
+		fmt.Println(err)
+		return 0, err
 	}
-	fmt.Printf("close and ink--------------------------------------------------------------\n")
-	println(close)
-	println(ink)
-	if ink > minerInk {
-		ink32 := int32(ink)
-		fmt.Printf("-------------------------------not enough ink---need %d----have %d-----------------\n", ink, minerInk)
-		err = InsufficientInkError(ink32)
-		return localMapPoints, ink, close, err
+	delete(shapes, shapeHash)
+	if index != nil {
+		index.Remove(shapeHash)
 	}
-	return localMapPoints, ink, close, nil
+	return len(pixels), nil
 }
 
-// this helper function convert from svg string to coordinates in the map
-// return :
-// localMapPoints map[int]point: Map of Points of this svg
-// ink int: needed to draw such svgpath
-// close bool: if svg is closed or not
-// err error:
-// 1: OutofBoundError: if any point is outside canvas size, return error
-func RemoveTransparentSvgToCoord(svgString string, publicKey string) (localMapPoints map[int]point, ink int, close bool, err error) {
-	initialPoint := point{x: 0, y: 0}
-	endPoint := point{x: 0, y: 0}
-	currentPoint := point{x: 0, y: 0}
-	var temPoint point
-	var p point
-	var j int
-	var s3 string
-	var points []point
-	ink = 0
-	close = false
-	localMapPoints = make(map[int]point)
-	index := 0
-	i := 0
-	for i < len(svgString) {
-		s := string(svgString[i : i+1])
-		//println(s)
-		if s == "M" || s == "m" {
-			s2 := s
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-
-			}
-			// parse x
-			if isNumber {
-				for isNumber {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "M" {
-					initialPoint.x = num
-					currentPoint.x = num
-				} else {
-					initialPoint.x = num + initialPoint.x
-					currentPoint.x = num + currentPoint.x
-				}
-				// println("x1")
-				// println(initialPoint.x)
-				s3 = ""
-			}
-			for s == " " {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "M" {
-					initialPoint.y = num
-					currentPoint.y = num
-				} else {
-					initialPoint.y = num + initialPoint.y
-					currentPoint.y = num + currentPoint.y
-				}
-				if !checkCanvasSize(currentPoint) {
-					err = OutOfBoundsError{}
-					return localMapPoints, ink, close, err
-				}
-				// println("y1")
-				// println(initialPoint.y)
-				s3 = ""
-			}
-		}
-		if s == "L" || s == "l" {
-			s2 := s
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse x
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					s = string(svgString[i : i+1])
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "L" {
-					temPoint.x = num
-				} else {
-					temPoint.x = num + currentPoint.x
-				}
-				// println("x3")
-				// println(temPoint.x)
-				s3 = ""
-			}
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "L" {
-					temPoint.y = num
-				} else {
-					temPoint.y = num + currentPoint.y
-				}
-				// println("y3")
-				// println(temPoint.y)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in L, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in L's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
-		}
-		if s == "H" || s == "h" {
-			s2 := s
-			temPoint.y = currentPoint.y
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse x
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "H" {
-					temPoint.x = num
-				} else {
-					temPoint.x = num + currentPoint.x
-				}
-				// println("x3")
-				// println(temPoint.x)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in H, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in H's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
-		}
-		if s == "V" || s == "v" {
-			s2 := s
-			temPoint.x = currentPoint.x
-			i++
-			isNumber := false
-			// parse string before next letter
-			s = string(svgString[i : i+1])
-			isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			for s == " " && len(svgString) > i {
-				i++
-				s = string(svgString[i : i+1])
-				isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-			}
-			// parse y
-			if isNumber {
-				for isNumber && len(svgString) > i {
-					s3 += s
-					i++
-					if len(svgString) > i {
-						s = string(svgString[i : i+1])
-					}
-					isNumber, _ = regexp.MatchString("[0-9]|[-]", s)
-				}
-				num, _ := strconv.Atoi(s3)
-				if s2 == "V" {
-					temPoint.y = num
-				} else {
-					temPoint.y = num + currentPoint.y
-				}
-				// println("y3")
-				// println(num)
-				s3 = ""
-			}
-			if !checkCanvasSize(temPoint) {
-				err = OutOfBoundsError{}
-				return localMapPoints, ink, close, err
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in V, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			// for key, value := range localMapPoints {
-			// 	fmt.Printf("in V's localMapPoints, key %d, pstring %s, index %d\n", key, value, index)
-			// }
-			currentPoint = temPoint
-			endPoint = temPoint
-		}
-		if s == "Z" || s == "z" {
-			temPoint.x = initialPoint.x
-			temPoint.y = initialPoint.y
-			i++
-			// parse string before next letter
-			for s == " " && len(svgString) > i {
-				i++
-				if len(svgString) > i {
-					s = string(svgString[i : i+1])
-				}
-			}
-			// detect conflict and put points into map
-			//fmt.Printf("in Z, temPoint.x %d,temPoint.y %d,currentPoint.x %d,currentPoint.y %d\n", temPoint.x, temPoint.y, currentPoint.x, currentPoint.y)
-			points = getPointsFromVertex(currentPoint.x, temPoint.x, currentPoint.y, temPoint.y)
-			// remove the last point because its the same as very first vertex
-			points = points[:len(points)-1]
-			for j, p = range points {
-				localMapPoints[index+j] = p
-			}
-			index = index + j
-			currentPoint = temPoint
-			endPoint = temPoint
+// move a shape on the canvas by dx, dy, the moved shape replaces the shape
+// in shapes and index and is checked against every shape but itself.
+// Moving does not change the pixels a shape covers, so it costs no ink.
+// return the svg path of the moved shape
+// args:
+// - shapeHash : key of the shape in shapes
+// - publicKey : key of the art node moving the shape
+// - dx, dy : offset to move the shape by
+// - canvas : canvas settings of the network
+// - index : spatial index over shapes, may be nil
+/////////////////
+// Can return the following errors:
+// - ShapeOwnerError: if shape is not on canvas or owned by someone else
+// - OutofBoundError: if any pixel of the moved shape is outside canvas size
+// - ShapeOverlapError: if moved shape overlaps a shape of another owner
+func MoveShapeInMap(shapeHash string, publicKey string, dx int, dy int, canvas CanvasSettings, shapes map[string]Shape, index *ShapeIndex) (svgString string, err error) {
+	shape, exist := shapes[shapeHash]
+	if !exist || shape.PublicKey != publicKey {
+		err = ShapeOwnerError(shapeHash)
+		fmt.Println(err)
+		return "", err
+	}
+	moved := shape
+	moved.Subpaths = make([][]Vertex, len(shape.Subpaths))
+	for i, subpath := range shape.Subpaths {
+		moved.Subpaths[i] = make([]Vertex, len(subpath))
+		for j, v := range subpath {
+			moved.Subpaths[i][j] = Vertex{v.X + dx, v.Y + dy}
 		}
-		i++
 	}
-	if endPoint == initialPoint {
-		close = true
+	moved.SvgString = subpathsPath(moved.Subpaths)
+	if _, _, err = shapePixels(moved.SvgString, moved.Fill, moved.StrokeWidth, canvas); err != nil {
+		fmt.Println(err)
+		return "", err
 	}
-	for _, _ = range localMapPoints {
-		//fmt.Printf("in localMapPoints, key %d, pstring %s\n", key, value)
-		ink++
+	// the shape itself has the same owner so it is never reported
+	if hash := firstOverlap(moved, shapes, index); hash != "" {
+		err = ShapeOverlapError(hash)
+		fmt.Println(err)
+		return "", err
 	}
-	return localMapPoints, ink, close, nil
+	shapes[shapeHash] = moved
+	if index != nil {
+		index.Insert(moved)
+	}
+	return moved.SvgString, nil
 }
 
-// if overlap return true, else return false
-func checkOverlap(p point, publicKey string, mapPoints map[string]MapPoint) bool {
-	pString := strconv.Itoa(p.x) + "," + strconv.Itoa(p.y)
-	mappoint, exist := mapPoints[pString]
-	if exist {
-		//check public key
-		if mappoint.PublicKey == publicKey {
-			return false
+// svg path with the given subpaths, using absolute commands only
+func subpathsPath(subpaths [][]Vertex) string {
+	paths := make([]string, len(subpaths))
+	for i, subpath := range subpaths {
+		n := len(subpath)
+		if n > 2 && subpath[0] == subpath[n-1] {
+			paths[i] = pointsPath(subpath[:n-1], true)
+		} else {
+			paths[i] = pointsPath(subpath, false)
 		}
-		return true
 	}
-	return false
-
+	return strings.Join(paths, " ")
 }
 
-// add point (pstring) to global map mapPoints
-func addPoint(p point, publicKey string, mapPoints map[string]MapPoint) {
-	pString := strconv.Itoa(p.x) + "," + strconv.Itoa(p.y)
-	mappoint, exist := mapPoints[pString]
-	if exist {
-		mappoint = MapPoint{Count: mappoint.Count + 1, PublicKey: publicKey}
-		mapPoints[pString] = mappoint
-	} else {
-		mappoint = MapPoint{Count: 1, PublicKey: publicKey}
-		mapPoints[pString] = mappoint
+// this helper function convert from svg string to the pixels the shape covers,
+// the number of pixels is the ink needed to draw it
+// return :
+// subpaths [][]Vertex: vertices of the svg path
+// pixels map[point]bool: set of pixels of outline, and of interior if filled
+// err error:
+// 1: InvalidShapeSvgStringError: if svg can not be parsed, or filled shape is
+//    not closed or has edges crossing each other
+// 2: OutofBoundError: if any point or pixel of the outline is outside canvas size
+func shapePixels(svgString string, shapeType string, strokeWidth int, canvas CanvasSettings) (subpaths [][]Vertex, pixels map[point]bool, err error) {
+	if strokeWidth < 1 || strokeWidth > MaxStrokeWidth(canvas) {
+		return nil, nil, InvalidShapeSvgStringError(fmt.Sprintf("%s: stroke width %d", svgString, strokeWidth))
 	}
-}
-
-// if global map have such point, return true, else return false
-//return ShapeOwnerError
-func havePoint(p point, publicKey string, mapPoints map[string]MapPoint) error {
-	pString := strconv.Itoa(p.x) + "," + strconv.Itoa(p.y)
-	mappoint, exist := mapPoints[pString]
-	if exist {
-		//check public key
-		if mappoint.PublicKey == publicKey {
-			return nil
+	subpaths, pixels, close, err := TransparentSvgToCoord(svgString, canvas)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strokeWidth > 1 {
+		err = thickOutline(subpaths, strokeWidth, pixels, canvas)
+		if err != nil {
+			return nil, nil, err
 		}
-		return ShapeOwnerError(pString)
 	}
-	return ShapeOwnerError(pString)
+	if !IsTransparent(shapeType) {
+		if !close {
+			return nil, nil, InvalidShapeSvgStringError(svgString)
+		}
+		err = checkSelfIntersection(svgString, subpaths)
+		if err != nil {
+			return nil, nil, err
+		}
+		FilledSvgToPolygon(subpaths, pixels)
+	}
+	return subpaths, pixels, nil
 }
 
-// remove point (pstring) from global map mapPoints
+// widest stroke a shape can be drawn with: the diagonal of the canvas, as
+// a wider stroke around any pixel of the canvas leaves it anyway
+func MaxStrokeWidth(canvas CanvasSettings) int {
+	return int(math.Hypot(float64(canvas.CanvasXMax), float64(canvas.CanvasYMax)))
+}
 
-func removePoint(p point, publicKey string, mapPoints map[string]MapPoint) {
-	pString := strconv.Itoa(p.x) + "," + strconv.Itoa(p.y)
-	mappoint, _ := mapPoints[pString]
-	mappoint.Count--
-	if mappoint.Count == 0 {
-		delete(mapPoints, pString)
-	}
+// check that a shape can be drawn on the canvas, without looking at other
+// shapes or ink
+// Can return the following errors:
+// - OutofBoundError: if any point is outside canvas size
+// - InvalidShapeSvgStringError: if svg can not be parsed, stroke width is
+//   less than 1 or more than MaxStrokeWidth, or filled shape is not closed or has edges crossing each other
+func ValidateShape(svgString string, shapeType string, strokeWidth int, canvas CanvasSettings) error {
+	_, _, err := shapePixels(svgString, shapeType, strokeWidth, canvas)
+	return err
 }
 
-//get all points between two vertexs
-// return array of points
-func getPointsFromVertex(x1 int, x2 int, y1 int, y2 int) []point {
-	var num float64
-	var slope int
-	var x, y int
-	if y2 == y1 {
-		num = math.Abs(float64(x2 - x1))
-	} else if x2 == x1 {
-		num = math.Abs(float64(y2 - y1))
-	} else {
-		num = math.Min(math.Abs(float64(y2-y1)), math.Abs(float64(x2-x1)))
-	}
-	quantity := int(num)
-	points := make([]point, quantity+1)
-	ydiff := y2 - y1
-	xdiff := x2 - x1
-	if ydiff == 0 || xdiff == 0 {
-		slope = 0
-	} else if math.Abs(float64(ydiff)) > math.Abs(float64(xdiff)) {
-		slope = ydiff / xdiff
-	} else {
-		slope = xdiff / ydiff
+// this helper function convert from svg string to coordinates of its outline
+// return :
+// subpaths [][]Vertex: vertices of the svg path
+// pixels map[point]bool: set of pixels on the outline
+// close bool: if every subpath of the svg is closed
+// err error:
+// 1: InvalidShapeSvgStringError: if svg can not be parsed
+// 2: OutofBoundError: if any point is outside canvas size
+func TransparentSvgToCoord(svgString string, canvas CanvasSettings) (subpaths [][]Vertex, pixels map[point]bool, close bool, err error) {
+	subpaths, err = parseSvgPath(svgString)
+	if err != nil {
+		return nil, nil, false, err
 	}
-	i := 0
-	for i < quantity {
-		if math.Abs(float64(xdiff)) < math.Abs(float64(ydiff)) {
-			if slope == 0 {
-				x = 0
-			} else {
-				x = xdiff / quantity * i
-			}
-			if slope == 0 {
-				y = ydiff / quantity * i
-			} else {
-				if xdiff < 0 {
-					y = -slope * i
-				} else {
-					y = slope * i
-				}
-			}
-			//fmt.Printf("quantity is %d, slope is %d, y is %d ydiff is %d\n", quantity, slope, y, ydiff)
-			points[i] = point{x + x1, y + y1}
-			i++
-		} else {
-			if slope == 0 {
-				y = 0
-			} else {
-				y = ydiff / quantity * i
+	pixels = make(map[point]bool)
+	close = len(subpaths) > 0
+	for _, subpath := range subpaths {
+		for i, v := range subpath {
+			if !checkCanvasSize(point{v.X, v.Y}, canvas) {
+				return nil, nil, false, OutOfBoundsError{v.X, v.Y}
 			}
-			if slope == 0 {
-				x = xdiff / quantity * i
-			} else {
-				if ydiff < 0 {
-					x = -slope * i
-				} else {
-					x = slope * i
+			if i > 0 {
+				for _, p := range getPointsFromVertex(subpath[i-1].X, v.X, subpath[i-1].Y, v.Y) {
+					pixels[p] = true
 				}
 			}
-			//fmt.Printf("quantity is %d, slope is %d, x is %d xdiff is %d\n", quantity, slope, x, xdiff)
-			points[i] = point{x + x1, y + y1}
-			i++
 		}
-
-	}
-	points[quantity].x = x2
-	points[quantity].y = y2
-	//fmt.Printf("%v\n", points)
-	return points
-}
-
-func checkCanvasSize(temPoint point) bool {
-	CanvasXMax := 1024
-	CanvasYMax := 1024
-	if temPoint.x > CanvasXMax || temPoint.x < 0 || temPoint.y > CanvasYMax || temPoint.y < 0 {
-		return false
+		if subpath[0] != subpath[len(subpath)-1] {
+			close = false
+		}
 	}
-	return true
+	return subpaths, pixels, close, nil
 }
 
-// this helper function convert from transparent line of point to array of points inside polygon
-// return list of Points of polygon and ink used
-// - InsufficientInkError: if given minerInk is less then ink needed
-func FilledSvgToPolygon(transparentMapPoints map[int]point, publicKey string, minerInk int) (polygon [][]bool, ink int, err error) {
-	maxY := 0
-	maxX := 0
-	ink = 0
-	for _, value := range transparentMapPoints {
-		if value.x > maxX {
-			maxX = value.x
-		}
-		if value.y > maxY {
-			maxY = value.y
-		}
-	}
-	//fmt.Printf("maxY %d, maxX %d\n", maxY, maxX)
-	polygon = make([][]bool, maxY+1)
-	for i := range polygon {
-		polygon[i] = make([]bool, maxX+1)
+//get all points between two vertexs with Bresenham's algorithm
+// return array of points, both vertexs included
+func getPointsFromVertex(x1 int, x2 int, y1 int, y2 int) []point {
+	dx := absInt(x2 - x1)
+	dy := -absInt(y2 - y1)
+	sx, sy := 1, 1
+	if x1 > x2 {
+		sx = -1
 	}
-	for _, value := range transparentMapPoints {
-		// fmt.Printf("x %d, y %d\n", value.x, value.y)
-		polygon[value.y][value.x] = true
+	if y1 > y2 {
+		sy = -1
 	}
-	//printPolygon(polygon)
-	// fill all points inside polygon
-	for j := range polygon {
-		include := false
-		count := 0
-		for k := range polygon[j] {
-			if polygon[j][k] {
-				count++
-			}
+	points := make([]point, 0, maxInt(dx, -dy)+1)
+	e := dx + dy
+	x, y := x1, y1
+	for {
+		points = append(points, point{x, y})
+		if x == x2 && y == y2 {
+			break
+		}
+		e2 := 2 * e
+		if e2 >= dy {
+			e += dy
+			x += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y += sy
 		}
-		if count == 1 {
-			ink++
-		}
-		if count > 1 {
-			// apply algorithm that include points between two vertex
-			for k := range polygon[j] {
-				if polygon[j][k] {
-					include = !include
-					ink++
-				} else {
-					if include {
-						polygon[j][k] = true
-						ink++
+	}
+	return points
+}
+
+// this helper function adds the pixels swept by a stroke of the given width
+// along the outline: every pixel within strokeWidth/2 of an edge
+// - OutofBoundError: if any of those pixels is outside canvas size
+func thickOutline(subpaths [][]Vertex, strokeWidth int, pixels map[point]bool, canvas CanvasSettings) error {
+	h := halfStroke(strokeWidth)
+	r := int(h)
+	for _, subpath := range subpaths {
+		for i := 0; i+1 < len(subpath); i++ {
+			a, b := subpath[i], subpath[i+1]
+			for x := minInt(a.X, b.X) - r; x <= maxInt(a.X, b.X)+r; x++ {
+				for y := minInt(a.Y, b.Y) - r; y <= maxInt(a.Y, b.Y)+r; y++ {
+					p := Vertex{x, y}
+					if pointSegmentDistSq(p, a, b) > h*h {
+						continue
+					}
+					if !checkCanvasSize(point{x, y}, canvas) {
+						return OutOfBoundsError{x, y}
 					}
+					pixels[point{x, y}] = true
 				}
 			}
 		}
-		//fmt.Printf("in polygon row %d and ink %d--------------------------------------------------------------\n", j, ink)
 	}
+	return nil
+}
 
-	fmt.Printf("in polygon close and ink--------------------------------------------------------------\n")
-	println(ink)
-	if ink > minerInk {
-		ink32 := int32(ink)
-		fmt.Printf("-------------------------------not enough ink---need %d----have %d-----------------\n", ink, minerInk)
-		return polygon, ink, InsufficientInkError(ink32)
+// returns false if the point is outside the canvas, which covers 0 to
+// CanvasXMax-1 and 0 to CanvasYMax-1
+func checkCanvasSize(temPoint point, canvas CanvasSettings) bool {
+	CanvasXMax := int(canvas.CanvasXMax)
+	CanvasYMax := int(canvas.CanvasYMax)
+	if temPoint.x >= CanvasXMax || temPoint.x < 0 || temPoint.y >= CanvasYMax || temPoint.y < 0 {
+		return false
 	}
-	//printPolygon(polygon)
-
-	return polygon, ink, nil
+	return true
 }
 
-// this helper function convert from transparent line of point to array of points inside polygon
-// return list of Points of polygon and ink returned
-// - InsufficientInkError: if given minerInk is less then ink needed
-func RemoveFilledSvgToPolygon(transparentMapPoints map[int]point, publicKey string) (polygon [][]bool, ink int, err error) {
-	maxY := 0
-	maxX := 0
-	ink = 0
-	for _, value := range transparentMapPoints {
-		if value.x > maxX {
-			maxX = value.x
+// this helper function adds all pixels inside the polygon to the pixels
+// of its outline, using an even-odd scanline fill
+// every subpath is a closed ring, each row is crossed with all edges that
+// span it (lower end included, upper end excluded, horizontal edges skipped)
+// and pixels between pairs of crossings are inside
+func FilledSvgToPolygon(subpaths [][]Vertex, pixels map[point]bool) {
+	var edges [][2]Vertex
+	minY, maxY := 0, -1
+	for _, subpath := range subpaths {
+		for i := 0; i+1 < len(subpath); i++ {
+			a, b := subpath[i], subpath[i+1]
+			if a.Y == b.Y {
+				continue
+			}
+			if a.Y > b.Y {
+				a, b = b, a
+			}
+			edges = append(edges, [2]Vertex{a, b})
+			if maxY < minY {
+				minY, maxY = a.Y, b.Y
+			}
+			minY = minInt(minY, a.Y)
+			maxY = maxInt(maxY, b.Y)
 		}
-		if value.y > maxY {
-			maxY = value.y
-		}
-	}
-	//fmt.Printf("maxY %d, maxX %d\n", maxY, maxX)
-	polygon = make([][]bool, maxY+1)
-	for i := range polygon {
-		polygon[i] = make([]bool, maxX+1)
 	}
-	for _, value := range transparentMapPoints {
-		// fmt.Printf("x %d, y %d\n", value.x, value.y)
-		polygon[value.y][value.x] = true
-	}
-	//printPolygon(polygon)
-	// fill all points inside polygon
-	for j := range polygon {
-		count := 0
-		for k := range polygon[j] {
-			if polygon[j][k] {
-				count++
+	for y := minY; y <= maxY; y++ {
+		// x of each crossing as the fraction num/den, den > 0
+		var nums, dens []int
+		for _, e := range edges {
+			a, b := e[0], e[1]
+			if a.Y <= y && y < b.Y {
+				nums = append(nums, a.X*(b.Y-a.Y)+(y-a.Y)*(b.X-a.X))
+				dens = append(dens, b.Y-a.Y)
 			}
 		}
-		if count == 1 {
-			ink++
-			println("count = 1 ****************************")
+		// insertion sort of crossings by x
+		for i := 1; i < len(nums); i++ {
+			for j := i; j > 0 && nums[j]*dens[j-1] < nums[j-1]*dens[j]; j-- {
+				nums[j], nums[j-1] = nums[j-1], nums[j]
+				dens[j], dens[j-1] = dens[j-1], dens[j]
+			}
 		}
-		if count > 1 {
-			// apply algorithm that include points between two vertex
-			for k := range polygon[j] {
-				include := false
-				if polygon[j][k] {
-					include = !include
-					ink++
-				} else {
-					if include {
-						polygon[j][k] = true
-						ink++
-					}
-				}
+		for i := 0; i+1 < len(nums); i += 2 {
+			from := ceilDiv(nums[i], dens[i])
+			to := floorDiv(nums[i+1], dens[i+1])
+			for x := from; x <= to; x++ {
+				pixels[point{x, y}] = true
 			}
 		}
 	}
-	//printPolygon(polygon)
+}
 
-	return polygon, ink, nil
+func absInt(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
 }
 
-func printPolygon(polygon [][]bool) {
-	var value3 string
-	for j := range polygon {
-		for k := range polygon[j] {
-			if polygon[j][k] {
-				value3 = "O"
-			} else {
-				value3 = "x"
-			}
-			fmt.Printf("%2s", value3)
-		}
-		fmt.Printf("\n")
+func floorDiv(num int, den int) int {
+	q := num / den
+	if num%den != 0 && num < 0 {
+		q--
+	}
+	return q
+}
+
+func ceilDiv(num int, den int) int {
+	q := num / den
+	if num%den != 0 && num > 0 {
+		q++
 	}
-	fmt.Printf("\n")
+	return q
 }
 
 ///////////////////////// main is used for testing  //////////////////
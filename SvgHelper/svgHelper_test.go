@@ -0,0 +1,554 @@
+package SvgHelper
+
+import (
+	"image/color"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+var testCanvas = CanvasSettings{CanvasXMax: 1024, CanvasYMax: 1024}
+
+func TestOverlap(t *testing.T) {
+	tests := []struct {
+		name    string
+		first   string
+		fill1   string
+		second  string
+		fill2   string
+		overlap bool
+	}{
+		{"crossing lines", "M 0 0 L 10 10", "transparent", "M 0 10 L 10 0", "transparent", true},
+		{"touching lines", "M 0 0 L 5 0", "transparent", "M 5 0 L 5 5", "transparent", true},
+		{"parallel lines", "M 0 0 L 0 5", "transparent", "M 1 0 L 1 5", "transparent", false},
+		{"line inside filled square", "M 0 0 l 10 0 v 10 h -10 z", "red", "M 2 2 L 8 8", "transparent", true},
+		{"line inside transparent square", "M 0 0 l 10 0 v 10 h -10 z", "transparent", "M 2 2 L 8 8", "transparent", false},
+		{"filled square around square", "M 2 2 l 3 0 v 3 h -3 z", "transparent", "M 0 0 l 10 0 v 10 h -10 z", "red", true},
+		{"line in notch of concave shape", "M 5 0 l 3 0 l 0 3 h 3 v -3 h 3 v 6 h -9 z", "red", "M 9 0 L 10 2", "transparent", false},
+		{"separate squares", "M 200 600 l 39 0 v 39 h -39 z", "red", "M 90 0 l 40 0 v 40 h -40 z", "red", false},
+		{"second subpath inside filled square", "M 0 0 l 10 0 v 10 h -10 z", "red", "M 20 20 L 30 30 M 2 2 L 8 8", "transparent", true},
+		{"line inside second ring of filled shape", "M 2 2 L 8 8", "transparent", "M 20 20 l 5 0 v 5 h -5 z M 0 0 l 10 0 v 10 h -10 z", "red", true},
+		{"second ring of filled shape around line", "M 20 20 l 5 0 v 5 h -5 z M 0 0 l 10 0 v 10 h -10 z", "red", "M 2 2 L 8 8", "transparent", true},
+		{"line in hole of filled shape", "M 0 0 l 20 0 v 20 h -20 z M 5 5 l 10 0 v 10 h -10 z", "red", "M 30 30 L 40 40 M 8 8 L 12 12", "transparent", false},
+	}
+	for _, test := range tests {
+		shapes := make(map[string]Shape)
+		_, err := AddShapeToMap("first", test.first, "owner1", test.fill1, 1, 10000, testCanvas, shapes, nil)
+		if err != nil {
+			t.Error(test.name, ": unexpected error adding first shape: ", err)
+			continue
+		}
+		_, err = AddShapeToMap("second", test.second, "owner2", test.fill2, 1, 10000, testCanvas, shapes, nil)
+		if _, ok := err.(ShapeOverlapError); ok != test.overlap {
+			t.Error(test.name, ": expected overlap ", test.overlap, ", got: ", err)
+		}
+		// shapes of the same owner never overlap
+		delete(shapes, "second")
+		_, err = AddShapeToMap("third", test.second, "owner1", test.fill2, 1, 10000, testCanvas, shapes, nil)
+		if err != nil {
+			t.Error(test.name, ": expected no error for same owner, got: ", err)
+		}
+	}
+}
+
+func TestRemoveShape(t *testing.T) {
+	shapes := make(map[string]Shape)
+	index := NewShapeIndex(shapes, testCanvas)
+	ink, err := AddShapeToMap("line", "M 0 0 L 0 5", "owner1", "transparent", 1, 300, testCanvas, shapes, index)
+	if err != nil || ink != 6 {
+		t.Error("Expected 6 ink, got: ", ink, err)
+	}
+	_, err = RemoveShapeFromMap("line", "owner2", testCanvas, shapes, index)
+	if _, ok := err.(ShapeOwnerError); !ok {
+		t.Error("Expected ShapeOwnerError, got: ", err)
+	}
+	ink, err = RemoveShapeFromMap("line", "owner1", testCanvas, shapes, index)
+	if err != nil || ink != 6 {
+		t.Error("Expected 6 ink returned, got: ", ink, err)
+	}
+	_, err = AddShapeToMap("cross", "M 0 2 L 5 2", "owner2", "transparent", 1, 300, testCanvas, shapes, index)
+	if err != nil {
+		t.Error("Expected no overlap after remove, got: ", err)
+	}
+}
+
+func TestShapeIndex(t *testing.T) {
+	shapes := make(map[string]Shape)
+	for x := 0; x < 1000; x += 25 {
+		for y := 0; y < 1000; y += 25 {
+			hash := strconv.Itoa(x) + "," + strconv.Itoa(y)
+			shapes[hash] = Shape{ShapeHash: hash, StrokeWidth: 1, Subpaths: [][]Vertex{{{x, y}, {x + 10, y + 10}}}}
+		}
+	}
+	index := NewShapeIndex(shapes, testCanvas)
+	index.Remove("500,500")
+	delete(shapes, "500,500")
+	queries := []Rect{{0, 0, 0, 0}, {490, 490, 560, 520}, {11, 11, 24, 24}, {0, 0, 1024, 1024}}
+	for _, q := range queries {
+		var expected []string
+		for hash, shape := range shapes {
+			if shape.bounds().intersects(q) {
+				expected = append(expected, hash)
+			}
+		}
+		got := index.Query(q)
+		sort.Strings(expected)
+		sort.Strings(got)
+		if len(got) != len(expected) {
+			t.Error("Expected ", len(expected), " shapes in ", q, ", got: ", len(got))
+			continue
+		}
+		for i := range got {
+			if got[i] != expected[i] {
+				t.Error("Expected ", expected, " in ", q, ", got: ", got)
+				break
+			}
+		}
+	}
+}
+
+// The root covers the whole canvas, so shapes far to the right of a wide
+// canvas go down the tree instead of piling up in the root.
+func TestShapeIndexCanvasSize(t *testing.T) {
+	canvas := CanvasSettings{CanvasXMax: 8000, CanvasYMax: 100}
+	shapes := make(map[string]Shape)
+	for x := 2000; x < 8000; x += 20 {
+		hash := strconv.Itoa(x)
+		shapes[hash] = Shape{ShapeHash: hash, StrokeWidth: 1, Subpaths: [][]Vertex{{{x, 10}, {x + 5, 10}}}}
+	}
+	index := NewShapeIndex(shapes, canvas)
+	if n := len(index.root.items); n > quadCapacity {
+		t.Error("Expected the shapes below the root, got ", n, " in the root")
+	}
+	if got := index.Query(Rect{7000, 0, 7010, 20}); len(got) != 1 || got[0] != "7000" {
+		t.Error("Expected shape 7000, got: ", got)
+	}
+}
+
+func TestInkReferenceShapes(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		fill string
+		ink  int
+	}{
+		{"vertical line", "M 0 0 L 0 5", "transparent", 6},
+		{"horizontal line", "M 0 0 L 5 0", "transparent", 6},
+		{"shallow line", "M 0 0 L 3 1", "transparent", 4},
+		{"steep line", "M 0 0 l 2 7", "transparent", 8},
+		{"diagonal line", "M 3 3 L 0 0", "transparent", 4},
+		{"square outline", "M 0 0 l 4 0 v 4 h -4 z", "transparent", 16},
+		{"filled square", "M 0 0 l 4 0 v 4 h -4 z", "red", 25},
+		{"filled triangle", "M 4 0 L 0 4 h 8 z", "red", 25},
+		{"filled trapezoid", "M 5 0 l 5 0 L 15 5 h -15 z", "red", 66},
+		{"filled concave", "M 5 0 l 3 0 l 0 3 h 3 v -3 h 3 v 6 h -9 z", "red", 64},
+		{"filled convex", "M 5 5 l 3 0 l 0 3 h 3 v 3 h -9 v -3 h 3 z", "red", 52},
+		{"filled diamond", "M 3 0 L 6 3 L 3 6 L 0 3 z", "red", 25},
+	}
+	for _, test := range tests {
+		shapes := make(map[string]Shape)
+		ink, err := AddShapeToMap("shape", test.svg, "owner1", test.fill, 1, 10000, testCanvas, shapes, nil)
+		if err != nil || ink != test.ink {
+			t.Error(test.name, ": expected ink ", test.ink, ", got: ", ink, err)
+			continue
+		}
+		refund, err := RemoveShapeFromMap("shape", "owner1", testCanvas, shapes, nil)
+		if err != nil || refund != ink {
+			t.Error(test.name, ": expected refund ", ink, ", got: ", refund, err)
+		}
+	}
+}
+
+func TestBresenhamLine(t *testing.T) {
+	expected := []point{{0, 0}, {1, 0}, {2, 1}, {3, 1}}
+	got := getPointsFromVertex(0, 3, 0, 1)
+	if len(got) != len(expected) {
+		t.Fatal("Expected ", expected, ", got: ", got)
+	}
+	for i := range got {
+		if got[i] != expected[i] {
+			t.Error("Expected ", expected, ", got: ", got)
+			break
+		}
+	}
+}
+
+func TestOutOfBounds(t *testing.T) {
+	small := CanvasSettings{CanvasXMax: 100, CanvasYMax: 50}
+	shapes := make(map[string]Shape)
+	_, err := AddShapeToMap("line", "M 0 0 L 99 49", "owner1", "transparent", 1, 10000, small, shapes, nil)
+	if err != nil {
+		t.Error("Expected no error, got: ", err)
+	}
+	// the canvas is 100 pixels wide, 0 to 99
+	_, err = AddShapeToMap("line2", "M 100 0 L 100 10", "owner1", "transparent", 1, 10000, small, shapes, nil)
+	if err != (OutOfBoundsError{100, 0}) {
+		t.Error("Expected OutOfBoundsError at 100,0, got: ", err)
+	}
+	_, err = AddShapeToMap("line2", "M 10 10 L 10 50", "owner1", "transparent", 1, 10000, small, shapes, nil)
+	if err != (OutOfBoundsError{10, 50}) {
+		t.Error("Expected OutOfBoundsError at 10,50, got: ", err)
+	}
+	_, err = AddShapeToMap("line3", "M 10 10 h -11", "owner1", "transparent", 1, 10000, small, shapes, nil)
+	if err != (OutOfBoundsError{-1, 10}) {
+		t.Error("Expected OutOfBoundsError at -1,10, got: ", err)
+	}
+}
+
+func TestSelfIntersectingShapes(t *testing.T) {
+	tests := []struct {
+		name  string
+		svg   string
+		fill  string
+		valid bool
+	}{
+		{"bow tie", "M 0 0 L 4 4 L 4 0 L 0 4 z", "red", false},
+		{"transparent bow tie", "M 0 0 L 4 4 L 4 0 L 0 4 z", "transparent", true},
+		{"square", "M 0 0 l 4 0 v 4 h -4 z", "red", true},
+		{"square closed twice", "M 0 0 l 4 0 v 4 h -4 v -4 z", "red", true},
+		{"square with hole", "M 0 0 l 10 0 v 10 h -10 z M 3 3 l 3 0 v 3 h -3 z", "red", true},
+		{"spike", "M 0 0 l 4 0 h -2 v 4 z", "red", false},
+		{"touching vertex", "M 0 0 l 4 0 L 2 2 L 4 4 L 0 4 L 2 2 z", "red", false},
+		{"crossing rings", "M 0 0 l 4 0 v 4 h -4 z M 2 2 l 4 0 v 4 h -4 z", "red", false},
+	}
+	for _, test := range tests {
+		err := ValidateShape(test.svg, test.fill, 1, testCanvas)
+		if test.valid && err != nil {
+			t.Error(test.name, ": expected valid, got: ", err)
+		}
+		if _, ok := err.(InvalidShapeSvgStringError); !test.valid && !ok {
+			t.Error(test.name, ": expected InvalidShapeSvgStringError, got: ", err)
+		}
+	}
+}
+
+func TestStrokeWidth(t *testing.T) {
+	tests := []struct {
+		name  string
+		svg   string
+		fill  string
+		width int
+		ink   int
+	}{
+		{"thin line", "M 10 10 L 20 10", "transparent", 1, 11},
+		{"width 2 line", "M 10 10 L 20 10", "transparent", 2, 35},
+		{"width 3 line", "M 10 10 L 20 10", "transparent", 3, 39},
+		{"width 4 line", "M 10 10 L 20 10", "transparent", 4, 63},
+		{"width 5 line", "M 10 10 L 20 10", "transparent", 5, 71},
+		{"width 2 square outline", "M 10 10 l 4 0 v 4 h -4 z", "transparent", 2, 44},
+		{"width 3 square outline", "M 10 10 l 4 0 v 4 h -4 z", "transparent", 3, 48},
+		{"width 4 square outline", "M 10 10 l 4 0 v 4 h -4 z", "transparent", 4, 69},
+		{"width 3 filled square", "M 10 10 l 4 0 v 4 h -4 z", "red", 3, 49},
+	}
+	for _, test := range tests {
+		if ink, err := InkCost(test.svg, test.fill, test.width, testCanvas); err != nil || ink != test.ink {
+			t.Error(test.name, ": expected ink cost ", test.ink, ", got: ", ink, err)
+		}
+		shapes := make(map[string]Shape)
+		ink, err := AddShapeToMap("shape", test.svg, "owner1", test.fill, test.width, 10000, testCanvas, shapes, nil)
+		if err != nil || ink != test.ink {
+			t.Error(test.name, ": expected ink ", test.ink, ", got: ", ink, err)
+			continue
+		}
+		refund, err := RemoveShapeFromMap("shape", "owner1", testCanvas, shapes, nil)
+		if err != nil || refund != ink {
+			t.Error(test.name, ": expected refund ", ink, ", got: ", refund, err)
+		}
+	}
+
+	// thick lines overlap where their thin outlines would not
+	shapes := make(map[string]Shape)
+	index := NewShapeIndex(shapes, testCanvas)
+	_, err := AddShapeToMap("thick", "M 10 10 L 20 10", "owner1", "transparent", 3, 10000, testCanvas, shapes, index)
+	if err != nil {
+		t.Error("Expected no error, got: ", err)
+	}
+	_, err = AddShapeToMap("near", "M 10 12 L 20 12", "owner2", "transparent", 1, 10000, testCanvas, shapes, index)
+	if err != nil {
+		t.Error("Expected no overlap 2 pixels away, got: ", err)
+	}
+	_, err = AddShapeToMap("close", "M 10 11 L 20 11", "owner2", "transparent", 1, 10000, testCanvas, shapes, index)
+	if err != ShapeOverlapError("thick") {
+		t.Error("Expected overlap with thick line, got: ", err)
+	}
+	_, err = AddShapeToMap("edge", "M 1 1 L 1 10", "owner2", "transparent", 3, 10000, testCanvas, shapes, index)
+	if err != nil {
+		t.Error("Expected no error, got: ", err)
+	}
+	_, err = AddShapeToMap("outside", "M 0 0 L 0 10", "owner1", "transparent", 3, 10000, testCanvas, shapes, index)
+	if err != (OutOfBoundsError{-1, -1}) {
+		t.Error("Expected OutOfBoundsError at -1,-1, got: ", err)
+	}
+
+	// the width is capped before the stroke is swept
+	tooWide := []int{MaxStrokeWidth(testCanvas) + 1, 1 << 30}
+	for _, width := range tooWide {
+		if _, err := InkCost("M 10 10 L 20 10", "transparent", width, testCanvas); err == nil {
+			t.Error("Expected an error for stroke width ", width)
+		}
+	}
+
+	// even widths reach half their width from the middle of the stroke
+	overlaps := []struct {
+		name    string
+		width   int
+		other   string
+		otherW  int
+		overlap bool
+	}{
+		{"width 2, thin line 1 away", 2, "M 10 11 L 20 11", 1, true},
+		{"width 2, thin line 2 away", 2, "M 10 12 L 20 12", 1, false},
+		{"width 2, width 2 line 2 away", 2, "M 10 12 L 20 12", 2, true},
+		{"width 2, width 2 line 3 away", 2, "M 10 13 L 20 13", 2, false},
+		{"width 4, thin line 2 away", 4, "M 10 12 L 20 12", 1, true},
+		{"width 4, thin line 3 away", 4, "M 10 13 L 20 13", 1, false},
+		{"width 4, width 4 line 4 away", 4, "M 10 14 L 20 14", 4, true},
+		{"width 4, width 4 line 5 away", 4, "M 10 15 L 20 15", 4, false},
+		{"width 4, thin line past the end", 4, "M 22 5 L 22 15", 1, true},
+		{"width 4, thin line further past the end", 4, "M 23 5 L 23 15", 1, false},
+	}
+	for _, test := range overlaps {
+		shapes := make(map[string]Shape)
+		index := NewShapeIndex(shapes, testCanvas)
+		_, err := AddShapeToMap("thick", "M 10 10 L 20 10", "owner1", "transparent", test.width, 10000, testCanvas, shapes, index)
+		if err != nil {
+			t.Fatal(test.name, ": expected no error, got: ", err)
+		}
+		_, err = AddShapeToMap("other", test.other, "owner2", "transparent", test.otherW, 10000, testCanvas, shapes, index)
+		if test.overlap && err != ShapeOverlapError("thick") {
+			t.Error(test.name, ": expected overlap, got: ", err)
+		}
+		if !test.overlap && err != nil {
+			t.Error(test.name, ": expected no overlap, got: ", err)
+		}
+	}
+}
+
+func TestEstimateShape(t *testing.T) {
+	shapes := make(map[string]Shape)
+	index := NewShapeIndex(shapes, testCanvas)
+	_, err := AddShapeToMap("square", "M 0 0 l 4 0 v 4 h -4 z", "owner1", "red", 1, 10000, testCanvas, shapes, index)
+	if err != nil {
+		t.Fatal("Expected no error, got: ", err)
+	}
+	estimate, err := EstimateShape("M 10 10 L 20 10", "owner2", "transparent", 1, testCanvas, shapes, index)
+	if err != nil || estimate != (ShapeEstimate{11, true, ""}) {
+		t.Error("Expected 11 ink in bounds, got: ", estimate, err)
+	}
+	estimate, err = EstimateShape("M 2 2 L 20 2", "owner2", "transparent", 1, testCanvas, shapes, index)
+	if err != nil || estimate != (ShapeEstimate{19, true, "square"}) {
+		t.Error("Expected overlap with square, got: ", estimate, err)
+	}
+	estimate, err = EstimateShape("M 2 2 L 2000 2", "owner2", "transparent", 1, testCanvas, shapes, index)
+	if err != nil || estimate != (ShapeEstimate{0, false, "square"}) {
+		t.Error("Expected out of bounds overlapping square, got: ", estimate, err)
+	}
+	_, err = EstimateShape("M 0 0 L 4 4 L 4 0 L 0 4 z", "owner2", "red", 1, testCanvas, shapes, index)
+	if _, ok := err.(InvalidShapeSvgStringError); !ok {
+		t.Error("Expected InvalidShapeSvgStringError, got: ", err)
+	}
+	if len(shapes) != 1 || len(index.Query(Rect{0, 0, 1024, 1024})) != 1 {
+		t.Error("Expected canvas to be unchanged, got: ", shapes)
+	}
+}
+
+func TestConvenienceShapes(t *testing.T) {
+	rect, err := RectPath(ShapeParams{X: 10, Y: 20, Width: 4, Height: 4})
+	if err != nil || rect != "M 10 20 h 4 v 4 h -4 z" {
+		t.Error("Expected rectangle path, got: ", rect, err)
+	}
+	filledInk, _ := AddShapeToMap("rect", rect, "owner1", "red", 1, 10000, testCanvas, make(map[string]Shape), nil)
+	if filledInk != 25 {
+		t.Error("Expected filled rectangle to cost 25 ink, got: ", filledInk)
+	}
+	polygon, err := PolygonPath(ShapeParams{Points: []Vertex{{0, 0}, {4, 0}, {4, 4}, {0, 4}}})
+	if err != nil || polygon != "M 0 0 L 4 0 L 4 4 L 0 4 z" {
+		t.Error("Expected polygon path, got: ", polygon, err)
+	}
+	polyline, err := PolylinePath(ShapeParams{Points: []Vertex{{0, 0}, {4, 0}, {4, 4}}})
+	if err != nil || polyline != "M 0 0 L 4 0 L 4 4" {
+		t.Error("Expected polyline path, got: ", polyline, err)
+	}
+	if _, err := PolygonPath(ShapeParams{Points: []Vertex{{0, 0}, {4, 0}}}); err == nil {
+		t.Error("Expected error for polygon with 2 points")
+	}
+	if _, err := RectPath(ShapeParams{X: 10, Y: 20, Width: 0, Height: 4}); err == nil {
+		t.Error("Expected error for rectangle without width")
+	}
+	for _, r := range [][2]int{{1, 1}, {2, 5}, {10, 1}, {30, 30}, {500, 200}} {
+		ellipse, err := EllipsePath(ShapeParams{Cx: 512, Cy: 512, Rx: r[0], Ry: r[1]})
+		if err != nil {
+			t.Error("Expected ellipse path, got: ", err)
+			continue
+		}
+		if err := ValidateShape(ellipse, "red", 1, testCanvas); err != nil {
+			t.Error("Expected filled ellipse to be valid, got: ", err)
+		}
+	}
+}
+
+func TestShapeElement(t *testing.T) {
+	tests := []struct {
+		shapeType int
+		params    ShapeParams
+		path      string
+		element   string
+	}{
+		{PathShape, ShapeParams{}, "M 0 0 L 0 5", `<path d="M 0 0 L 0 5" stroke="red" stroke-width="2" fill="transparent"/>`},
+		{RectShape, ShapeParams{X: 10, Y: 20, Width: 4, Height: 4}, "M 10 20 h 4 v 4 h -4 z",
+			`<rect x="10" y="20" width="4" height="4" stroke="red" stroke-width="2" fill="transparent"/>`},
+		{PolylineShape, ShapeParams{Points: []Vertex{{0, 0}, {4, 0}, {4, 4}}}, "M 0 0 L 4 0 L 4 4",
+			`<polyline points="0,0 4,0 4,4" stroke="red" stroke-width="2" fill="transparent"/>`},
+	}
+	for _, test := range tests {
+		path, element, err := ShapeElement(test.shapeType, "M 0 0 L 0 5", test.params, "transparent", "red", 2)
+		if err != nil || path != test.path || element != test.element {
+			t.Error("Expected ", test.path, " drawn as ", test.element, " got: ", path, element, err)
+		}
+	}
+	if _, _, err := ShapeElement(PathShape, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red\" onload=\"", 1); err != InvalidColourError("red\" onload=\"") {
+		t.Error("Expected InvalidColourError for the stroke, got: ", err)
+	}
+	points := make([]Vertex, 65)
+	for i := range points {
+		points[i] = Vertex{i, i % 2}
+	}
+	if _, _, err := ShapeElement(PolygonShape, "", ShapeParams{Points: points}, "red", "red", 1); err == nil {
+		t.Error("Expected error for polygon with 65 points")
+	}
+	if _, _, err := ShapeElement(7, "", ShapeParams{}, "red", "red", 1); err == nil {
+		t.Error("Expected error for unknown shape type")
+	}
+}
+
+func TestValidateColour(t *testing.T) {
+	valid := []string{"transparent", "red", "RebeccaPurple", "#fff", "#00FF7f", "rgb(0, 128, 255)", "rgb(10%,0%,100%)"}
+	for _, colour := range valid {
+		if err := ValidateColour(colour); err != nil {
+			t.Error("Expected valid colour, got: ", err)
+		}
+	}
+	invalid := []string{"", "reddish", "#ffff", "#ggg", "rgb(0,0)", "rgb(0,0,256)", "rgb(-1,0,0)",
+		"rgb(0,0,101%)", "red\" onload=\"alert(1)", "red\"/><script>alert(1)</script><path d=\""}
+	for _, colour := range invalid {
+		if _, ok := ValidateColour(colour).(InvalidColourError); !ok {
+			t.Error("Expected InvalidColourError for: ", colour)
+		}
+	}
+}
+
+func TestTransparentAnyCase(t *testing.T) {
+	want, _ := InkCost("M 0 0 L 0 5", "transparent", 1, testCanvas)
+	for _, fill := range []string{"Transparent", "TRANSPARENT"} {
+		if ink, err := InkCost("M 0 0 L 0 5", fill, 1, testCanvas); err != nil || ink != want {
+			t.Error("Expected open path with fill ", fill, " to cost ", want, " ink, got: ", ink, err)
+		}
+		if (Shape{Fill: fill}).filled() {
+			t.Error("Expected shape with fill ", fill, " not to be filled")
+		}
+	}
+}
+
+func TestMoveShape(t *testing.T) {
+	shapes := make(map[string]Shape)
+	index := NewShapeIndex(shapes, testCanvas)
+	cost, _ := AddShapeToMap("square", "M 0 0 l 4 0 v 4 h -4 z", "owner1", "red", 1, 10000, testCanvas, shapes, index)
+	AddShapeToMap("line", "M 20 0 L 20 10", "owner2", "transparent", 1, 10000, testCanvas, shapes, index)
+
+	// overlapping its own old position is fine
+	svg, err := MoveShapeInMap("square", "owner1", 2, 2, testCanvas, shapes, index)
+	if err != nil || svg != "M 2 2 L 6 2 L 6 6 L 2 6 z" {
+		t.Error("Expected moved square, got: ", svg, err)
+	}
+	if len(index.Query(Rect{0, 0, 1, 1})) != 0 || len(index.Query(Rect{6, 6, 6, 6})) != 1 {
+		t.Error("Expected index to follow the moved square")
+	}
+	if _, err := MoveShapeInMap("square", "owner1", 15, 0, testCanvas, shapes, index); err != ShapeOverlapError("line") {
+		t.Error("Expected ShapeOverlapError(line), got: ", err)
+	}
+	if _, err := MoveShapeInMap("square", "owner1", -3, 0, testCanvas, shapes, index); err != (OutOfBoundsError{-1, 2}) {
+		t.Error("Expected OutOfBoundsError, got: ", err)
+	}
+	if _, err := MoveShapeInMap("square", "owner2", 1, 1, testCanvas, shapes, index); err != ShapeOwnerError("square") {
+		t.Error("Expected ShapeOwnerError, got: ", err)
+	}
+	// failed moves leave the shape where it was
+	if shapes["square"].SvgString != "M 2 2 L 6 2 L 6 6 L 2 6 z" {
+		t.Error("Expected square to stay in place, got: ", shapes["square"].SvgString)
+	}
+	refund, err := RemoveShapeFromMap("square", "owner1", testCanvas, shapes, index)
+	if err != nil || refund != cost {
+		t.Error("Expected refund of ", cost, " got: ", refund, err)
+	}
+}
+
+func TestAddShapesBatch(t *testing.T) {
+	shapes := make(map[string]Shape)
+	index := NewShapeIndex(shapes, testCanvas)
+	AddShapeToMap("line", "M 20 0 L 20 10", "owner2", "transparent", 1, 10000, testCanvas, shapes, index)
+
+	batch := []Shape{
+		{ShapeHash: "a", PublicKey: "owner1", SvgString: "M 0 0 l 4 0 v 4 h -4 z", Fill: "red", StrokeWidth: 1},
+		{ShapeHash: "b", PublicKey: "owner1", SvgString: "M 10 0 L 10 10", Fill: "transparent", StrokeWidth: 1},
+	}
+	ink, err := AddShapesToMap(batch, 36, testCanvas, shapes, index)
+	if err != nil || ink != 36 || len(shapes) != 3 {
+		t.Error("Expected batch to cost 36 ink, got: ", ink, err)
+	}
+
+	overlapsCanvas := []Shape{
+		{ShapeHash: "c", PublicKey: "owner1", SvgString: "M 30 0 L 40 0", Fill: "transparent", StrokeWidth: 1},
+		{ShapeHash: "d", PublicKey: "owner1", SvgString: "M 15 5 L 25 5", Fill: "transparent", StrokeWidth: 1},
+	}
+	if _, err := AddShapesToMap(overlapsCanvas, 10000, testCanvas, shapes, index); err != ShapeOverlapError("line") {
+		t.Error("Expected ShapeOverlapError(line), got: ", err)
+	}
+	overlapsBatch := []Shape{
+		{ShapeHash: "e", PublicKey: "owner1", SvgString: "M 30 0 L 40 0", Fill: "transparent", StrokeWidth: 1},
+		{ShapeHash: "f", PublicKey: "owner1", SvgString: "M 35 0 L 35 5", Fill: "transparent", StrokeWidth: 1},
+	}
+	if _, err := AddShapesToMap(overlapsBatch, 10000, testCanvas, shapes, index); err != ShapeOverlapError("e") {
+		t.Error("Expected ShapeOverlapError(e), got: ", err)
+	}
+	tooExpensive := []Shape{
+		{ShapeHash: "g", PublicKey: "owner1", SvgString: "M 30 0 L 40 0", Fill: "transparent", StrokeWidth: 1},
+		{ShapeHash: "h", PublicKey: "owner1", SvgString: "M 30 5 L 40 5", Fill: "transparent", StrokeWidth: 1},
+	}
+	if _, err := AddShapesToMap(tooExpensive, 21, testCanvas, shapes, index); err != InsufficientInkError(21) {
+		t.Error("Expected InsufficientInkError(21), got: ", err)
+	}
+	if len(shapes) != 3 || len(index.Query(Rect{30, 0, 40, 5})) != 0 {
+		t.Error("Expected failed batches to add nothing, got: ", shapes)
+	}
+}
+
+func TestShapePixels(t *testing.T) {
+	outline, interior, err := ShapePixels("M 0 0 l 4 0 v 4 h -4 z", "red", 1, testCanvas)
+	if err != nil || len(outline) != 16 || len(interior) != 9 {
+		t.Error("Expected 16 outline and 9 interior pixels, got: ", len(outline), len(interior), err)
+	}
+	if len(interior) > 0 && (interior[0] != Vertex{1, 1} || interior[len(interior)-1] != Vertex{3, 3}) {
+		t.Error("Expected interior sorted from (1,1) to (3,3), got: ", interior)
+	}
+	_, interior, _ = ShapePixels("M 0 0 l 4 0 v 4 h -4 z", "transparent", 1, testCanvas)
+	if len(interior) != 0 {
+		t.Error("Expected no interior for transparent shape, got: ", interior)
+	}
+}
+
+func TestParseColour(t *testing.T) {
+	colours := map[string]color.RGBA{
+		"transparent":      {0, 0, 0, 0},
+		"Green":            {0, 128, 0, 255},
+		"#f0a":             {255, 0, 170, 255},
+		"#102030":          {16, 32, 48, 255},
+		"rgb(1, 2, 3)":     {1, 2, 3, 255},
+		"rgb(0%,50%,100%)": {0, 128, 255, 255},
+	}
+	for colour, expected := range colours {
+		rgba, err := ParseColour(colour)
+		if err != nil || rgba != expected {
+			t.Error("Expected ", expected, " for ", colour, " got: ", rgba, err)
+		}
+	}
+}
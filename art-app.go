@@ -4,7 +4,10 @@ A trivial application to illustrate how the blockartlib library can be
 used from an application in project 1 for UBC CS 416 2017W2.
 
 Usage:
-go run art-app.go miner-addr privKey
+go run art-app.go miner-addr privKey [output]
+
+The canvas is written to output.svg and output.png when output is given,
+otherwise the svg document is printed to stdout.
 */
 
 package main
@@ -24,8 +27,8 @@ func main() {
 	// minerAddr := "127.0.0.1:8088"
 	// privKey := // TODO: use crypto/ecdsa to read pub/priv keys from a file argument.
 
-	if len(os.Args) != 3 {
-		fmt.Println("Server address [ip:port] privatekeyString")
+	if len(os.Args) != 3 && len(os.Args) != 4 {
+		fmt.Println("Server address [ip:port] privatekeyString [output]")
 		return
 	}
 	minerAddr := os.Args[1]
@@ -41,6 +44,10 @@ func main() {
 		return
 	}
 
+	if len(os.Args) == 4 {
+		canvas.SetOutput(os.Args[3]+".svg", os.Args[3]+".png")
+	}
+
 	validateNum := uint8(2)
 	fmt.Print(canvas, "ignore", validateNum)
 	// Add a line.
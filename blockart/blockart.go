@@ -0,0 +1,395 @@
+/*
+
+A command-line client for everyday canvas operations, built on blockartlib.
+
+Usage:
+go run blockart.go [flags] command [command flags] [args]
+
+Flags:
+  -miner ip:port    art node address of the miner
+  -key hex          miner private key
+  -keystore file    file of "key minerPort artAppPort" entries, used for
+                    whatever -miner and -key do not give (default minerKey.txt)
+  -entry n          entry of the keystore to use (default 0)
+  -artkey hex       key that owns the shapes, the miner key if not given
+  -json             print results as JSON
+
+Commands:
+  add [-validate n] [-fill c] [-stroke c] [-width w] path "M 0 0 L 5 5"
+  add [...] rect x y width height
+  add [...] ellipse cx cy rx ry
+  add [...] polygon|polyline "x,y x,y ..."
+  delete [-validate n] shapeHash
+  move [-validate n] shapeHash dx dy
+  ink
+  shapes blockHash
+  children blockHash
+  genesis
+  block blockHash
+  svg shapeHash
+  canvas [-block hash]
+  export [-block hash] file.svg|file.png
+*/
+
+package main
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"../SvgHelper"
+	"../blockartlib"
+	"../render"
+)
+
+var jsonOutput bool
+
+func main() {
+	minerAddr := flag.String("miner", "", "art node address of the miner, ip:port")
+	keyString := flag.String("key", "", "miner private key, hex")
+	keystore := flag.String("keystore", "minerKey.txt", "file of \"key minerPort artAppPort\" entries")
+	entry := flag.Int("entry", 0, "entry of the keystore to use")
+	artKeyString := flag.String("artkey", "", "key that owns the shapes, hex, the miner key if not given")
+	flag.BoolVar(&jsonOutput, "json", false, "print results as JSON")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: blockart [flags] add|delete|move|ink|shapes|children|genesis|block|svg|canvas|export [args]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if *minerAddr == "" || *keyString == "" {
+		key, addr, err := readKeystore(*keystore, *entry)
+		if checkError(err) != nil {
+			os.Exit(1)
+		}
+		if *minerAddr == "" {
+			*minerAddr = addr
+		}
+		if *keyString == "" {
+			*keyString = key
+		}
+	}
+	privKey, err := parseKey(*keyString)
+	if checkError(err) != nil {
+		os.Exit(1)
+	}
+	artKey := privKey
+	if *artKeyString != "" {
+		artKey, err = parseKey(*artKeyString)
+		if checkError(err) != nil {
+			os.Exit(1)
+		}
+	}
+
+	canvas, settings, err := blockartlib.OpenCanvasAs([]string{*minerAddr}, *privKey, *artKey)
+	if checkError(err) != nil {
+		os.Exit(1)
+	}
+	if checkError(run(canvas, settings, flag.Arg(0), flag.Args()[1:])) != nil {
+		os.Exit(1)
+	}
+}
+
+// Runs the command and prints its result.
+func run(canvas blockartlib.Canvas, settings blockartlib.CanvasSettings, command string, args []string) error {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	validateNum := flags.Uint("validate", 2, "number of blocks to wait for after the op's block")
+	// parses the command flags, -validate has to fit in a uint8
+	parse := func() error {
+		if err := flags.Parse(args); err != nil {
+			return err
+		}
+		if *validateNum > math.MaxUint8 {
+			return fmt.Errorf("-validate is %d, at most %d", *validateNum, math.MaxUint8)
+		}
+		return nil
+	}
+	switch command {
+	case "add":
+		fill := flags.String("fill", "transparent", "fill colour")
+		stroke := flags.String("stroke", "black", "stroke colour")
+		width := flags.Uint("width", 1, "stroke width")
+		if err := parse(); err != nil {
+			return err
+		}
+		if *width > math.MaxUint32 {
+			return fmt.Errorf("-width is %d, at most %d", *width, uint32(math.MaxUint32))
+		}
+		if flags.NArg() < 2 {
+			return errors.New("add needs a shape type and its geometry")
+		}
+		shapeType, params, svg, err := parseShape(flags.Arg(0), flags.Args()[1:])
+		if err != nil {
+			return err
+		}
+		var shapeHash, blockHash string
+		var ink uint32
+		if shapeType == blockartlib.PATH {
+			shapeHash, blockHash, ink, err = canvas.AddShapeWithStroke(uint8(*validateNum), shapeType, svg, *fill, *stroke, uint32(*width))
+		} else {
+			shapeHash, blockHash, ink, err = canvas.AddShapeWithParams(uint8(*validateNum), shapeType, params, *fill, *stroke, uint32(*width))
+		}
+		if err != nil {
+			return err
+		}
+		return output(struct {
+			ShapeHash    string
+			BlockHash    string
+			InkRemaining uint32
+		}{shapeHash, blockHash, ink}, shapeHash+" "+blockHash+" "+fmt.Sprint(ink))
+	case "delete":
+		if err := parse(); err != nil {
+			return err
+		}
+		if flags.NArg() != 1 {
+			return errors.New("delete needs a shape hash")
+		}
+		ink, err := canvas.DeleteShape(uint8(*validateNum), flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		return output(struct{ InkRemaining uint32 }{ink}, fmt.Sprint(ink))
+	case "move":
+		if err := parse(); err != nil {
+			return err
+		}
+		if flags.NArg() != 3 {
+			return errors.New("move needs a shape hash, dx and dy")
+		}
+		dx, errX := strconv.Atoi(flags.Arg(1))
+		dy, errY := strconv.Atoi(flags.Arg(2))
+		if errX != nil || errY != nil {
+			return errors.New("dx and dy must be integers")
+		}
+		blockHash, ink, err := canvas.MoveShape(uint8(*validateNum), flags.Arg(0), dx, dy)
+		if err != nil {
+			return err
+		}
+		return output(struct {
+			BlockHash    string
+			InkRemaining uint32
+		}{blockHash, ink}, blockHash+" "+fmt.Sprint(ink))
+	case "ink":
+		ink, err := canvas.GetInk()
+		if err != nil {
+			return err
+		}
+		return output(struct{ InkRemaining uint32 }{ink}, fmt.Sprint(ink))
+	case "shapes", "children", "block", "svg":
+		if len(args) != 1 {
+			return errors.New(command + " needs a hash")
+		}
+		switch command {
+		case "shapes":
+			hashes, err := canvas.GetShapes(args[0])
+			if err != nil {
+				return err
+			}
+			return output(hashes, strings.Join(hashes, "\n"))
+		case "children":
+			hashes, err := canvas.GetChildren(args[0])
+			if err != nil {
+				return err
+			}
+			return output(hashes, strings.Join(hashes, "\n"))
+		case "block":
+			block, err := canvas.GetBlock(args[0])
+			if err != nil {
+				return err
+			}
+			text := fmt.Sprintf("hash %s\nprev %s\nheight %d\nnonce %d\nminer %s\nno-op %t\nink awarded %d",
+				block.BlockHash, block.PrevHash, block.Height, block.Nonce, block.MinerKey, block.NoOpBlock, block.InkAwarded)
+			for _, op := range block.Ops {
+				text += fmt.Sprintf("\n%s %s %s %d", op.Kind, op.ShapeHash, op.Owner, op.InkCost)
+			}
+			return output(block, text)
+		}
+		svg, err := canvas.GetSvgString(args[0])
+		if err != nil {
+			return err
+		}
+		return output(struct{ SvgString string }{svg}, svg)
+	case "genesis":
+		hash, err := canvas.GetGenesisBlock()
+		if err != nil {
+			return err
+		}
+		return output(struct{ BlockHash string }{hash}, hash)
+	case "canvas", "export":
+		blockHash := flags.String("block", "", "block of the canvas, the tip if not given")
+		if err := parse(); err != nil {
+			return err
+		}
+		shapes, err := canvas.GetCanvas(*blockHash)
+		if err != nil {
+			return err
+		}
+		if command == "canvas" {
+			lines := make([]string, len(shapes))
+			for i, s := range shapes {
+				lines[i] = s.ShapeHash + " " + s.BlockHash + " " + s.SvgString
+			}
+			return output(shapes, strings.Join(lines, "\n"))
+		}
+		if flags.NArg() != 1 {
+			return errors.New("export needs a .svg or .png file")
+		}
+		file := flags.Arg(0)
+		rendered := blockartlib.RenderShapes(shapes)
+		canvasSettings := SvgHelper.CanvasSettings(settings)
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".svg":
+			err = render.WriteSVG(file, rendered, canvasSettings)
+		case ".png":
+			err = render.WritePNG(file, rendered, canvasSettings)
+		default:
+			err = errors.New("export writes .svg or .png files, not " + file)
+		}
+		if err != nil {
+			return err
+		}
+		return output(struct {
+			File   string
+			Shapes int
+		}{file, len(shapes)}, file)
+	}
+	return errors.New("unknown command " + command)
+}
+
+// Returns the shape type and its geometry from the add arguments.
+func parseShape(kind string, args []string) (shapeType blockartlib.ShapeType, params blockartlib.ShapeParams, svg string, err error) {
+	ints := func(n int) ([]int, error) {
+		if len(args) != n {
+			return nil, fmt.Errorf("%s needs %d numbers", kind, n)
+		}
+		values := make([]int, n)
+		for i, arg := range args {
+			if values[i], err = strconv.Atoi(arg); err != nil {
+				return nil, fmt.Errorf("%s needs %d numbers", kind, n)
+			}
+		}
+		return values, nil
+	}
+	switch kind {
+	case "path":
+		return blockartlib.PATH, params, strings.Join(args, " "), nil
+	case "rect":
+		v, err := ints(4)
+		if err != nil {
+			return 0, params, "", err
+		}
+		params.X, params.Y, params.Width, params.Height = v[0], v[1], v[2], v[3]
+		return blockartlib.RECT, params, "", nil
+	case "ellipse":
+		v, err := ints(4)
+		if err != nil {
+			return 0, params, "", err
+		}
+		params.Cx, params.Cy, params.Rx, params.Ry = v[0], v[1], v[2], v[3]
+		return blockartlib.ELLIPSE, params, "", nil
+	case "polygon", "polyline":
+		for _, pair := range strings.Fields(strings.Join(args, " ")) {
+			xy := strings.Split(pair, ",")
+			if len(xy) != 2 {
+				return 0, params, "", errors.New("points are given as x,y")
+			}
+			x, errX := strconv.Atoi(xy[0])
+			y, errY := strconv.Atoi(xy[1])
+			if errX != nil || errY != nil {
+				return 0, params, "", errors.New("points are given as x,y")
+			}
+			params.Points = append(params.Points, blockartlib.Point{X: x, Y: y})
+		}
+		if kind == "polygon" {
+			return blockartlib.POLYGON, params, "", nil
+		}
+		return blockartlib.POLYLINE, params, "", nil
+	}
+	return 0, params, "", errors.New("unknown shape type " + kind)
+}
+
+// Returns the key and art node address of an entry of the keystore. Each
+// entry is a line "key minerPort artAppPort"; other lines are skipped.
+func readKeystore(file string, entry int) (key string, minerAddr string, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 4096), 1<<20)
+	n := 0
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		if n == entry {
+			return fields[0], "127.0.0.1:" + fields[2], nil
+		}
+		n++
+	}
+	if err := scanner.Err(); err != nil {
+		return "", "", err
+	}
+	return "", "", fmt.Errorf("keystore %s has no entry %d", file, entry)
+}
+
+func parseKey(keyString string) (*ecdsa.PrivateKey, error) {
+	keyBytes, err := hex.DecodeString(keyString)
+	if err != nil {
+		return nil, blockartlib.InvalidMinerPKError(keyString)
+	}
+	key, err := x509.ParseECPrivateKey(keyBytes)
+	if err != nil {
+		return nil, blockartlib.InvalidMinerPKError(keyString)
+	}
+	return key, nil
+}
+
+// Prints value as JSON with -json, else text.
+func output(value interface{}, text string) error {
+	if !jsonOutput {
+		if text != "" {
+			fmt.Println(text)
+		}
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+// If error is non-nil, print it out and return it. With -json it is
+// printed as {"Error": type, "Message": text}.
+func checkError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if jsonOutput {
+		kind := fmt.Sprintf("%T", err)
+		kind = kind[strings.LastIndex(kind, ".")+1:]
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Error   string
+			Message string
+		}{kind, err.Error()})
+	} else {
+		fmt.Fprintln(os.Stderr, "blockart:", err)
+	}
+	return err
+}
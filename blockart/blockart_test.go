@@ -0,0 +1,54 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"../blockartlib"
+	"../blockartlib/blockarttest"
+)
+
+func TestRun(t *testing.T) {
+	settings := blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}
+	canvas := blockarttest.NewCanvas(settings, 1000)
+	commands := [][]string{
+		{"add", "-stroke", "red", "path", "M 0 0 L 0 5"},
+		{"add", "-fill", "blue", "rect", "10", "10", "5", "5"},
+		{"add", "polyline", "20,20 30,20 30,30"},
+		{"ink"},
+		{"genesis"},
+		{"canvas"},
+		{"export", filepath.Join(t.TempDir(), "canvas.png")},
+	}
+	for _, args := range commands {
+		if err := run(canvas, settings, args[0], args[1:]); err != nil {
+			t.Error("Expected ", args, " to succeed, got: ", err)
+		}
+	}
+	if shapes, _ := canvas.GetCanvas(""); len(shapes) != 3 {
+		t.Error("Expected 3 shapes, got: ", shapes)
+	}
+	if err := run(canvas, settings, "add", []string{"rect", "1", "2"}); err == nil {
+		t.Error("Expected an error for a rect without size")
+	}
+	if err := run(canvas, settings, "export", []string{"canvas.gif"}); err == nil {
+		t.Error("Expected an error for a gif")
+	}
+
+	// flags out of range or unknown are errors, not truncated or exits
+	rejected := [][]string{
+		{"add", "-validate", "300", "path", "M 50 50 L 50 55"},
+		{"add", "-width", "4294967297", "path", "M 50 50 L 50 55"},
+		{"add", "-nosuchflag", "path", "M 50 50 L 50 55"},
+		{"delete", "-validate", "256", "shape"},
+		{"move", "-validate", "x", "shape", "1", "1"},
+	}
+	for _, args := range rejected {
+		if err := run(canvas, settings, args[0], args[1:]); err == nil {
+			t.Error("Expected an error for ", args)
+		}
+	}
+	if shapes, _ := canvas.GetCanvas(""); len(shapes) != 3 {
+		t.Error("Expected no shapes added by rejected commands, got: ", shapes)
+	}
+}
@@ -8,16 +8,24 @@ library (blockartlib) to be used in project 1 of UBC CS 416 2017W2.
 package blockartlib
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/rpc"
 	"os"
 	"regexp"
-	"strings"
+	"strconv"
+	"sync"
+	"time"
+
+	"../SvgHelper"
+	"../render"
 )
 
 // Represents a type of shape in the BlockArt system.
@@ -27,6 +35,18 @@ const (
 	// Path shape.
 	PATH ShapeType = iota
 
+	// Rectangle given by ShapeParams X, Y, Width and Height.
+	RECT
+
+	// Ellipse given by ShapeParams Cx, Cy, Rx and Ry.
+	ELLIPSE
+
+	// Closed shape through ShapeParams.Points.
+	POLYGON
+
+	// Open line through ShapeParams.Points.
+	POLYLINE
+
 	// Circle shape (extra credit).
 	// CIRCLE
 )
@@ -61,16 +81,34 @@ type MinerNetSettings struct {
 	PoWDifficultyNoOpBlock uint8
 
 	// Canvas settings
-	canvasSettings CanvasSettings
+	CanvasSettings CanvasSettings
 }
 
 type MyCanvas struct {
-	conn             *rpc.Client
+	sync.Mutex                     // guards conn, reconnected, connErr, current and minerNetSettings
+	conn             *rpc.Client   // nil until connected again after a failure
+	reconnected      chan struct{} // closed when the reconnect in progress ends, nil if there is none
+	connErr          error         // why the last reconnect failed
+	minerAddrs       []string      // miners controlling the miner key, in order of preference
+	current          int           // index in minerAddrs of the miner conn is to
 	minerPrivKey     ecdsa.PrivateKey
 	minerNetSettings MinerNetSettings
 	artnodePrivKey   string
+	svgPath          string // files CloseCanvas renders the canvas to
+	pngPath          string
 }
 
+// rounds over all miner addresses before giving up on reconnecting
+const reconnectRounds = 5
+
+var (
+	// wait after the first round of failed connects, doubled every round
+	reconnectMinBackoff = 200 * time.Millisecond
+	reconnectMaxBackoff = 5 * time.Second
+	// longest a miner gets to accept the connection and the miner key
+	connectTimeout = 5 * time.Second
+)
+
 type ValidMiner struct {
 	MinerNetSets MinerNetSettings
 	Valid        bool
@@ -129,11 +167,14 @@ func (e ShapeOwnerError) Error() string {
 	return fmt.Sprintf("BlockArt: Shape owned by someone else [%s]", string(e))
 }
 
-// Empty
-type OutOfBoundsError struct{}
+// Contains the coordinate that is outside the canvas.
+type OutOfBoundsError struct {
+	X int
+	Y int
+}
 
 func (e OutOfBoundsError) Error() string {
-	return fmt.Sprintf("BlockArt: Shape is outside the bounds of the canvas")
+	return fmt.Sprintf("BlockArt: Shape is outside the bounds of the canvas [%d,%d]", e.X, e.Y)
 }
 
 // Contains the hash of the shape that this shape overlaps with.
@@ -150,6 +191,21 @@ func (e InvalidBlockHashError) Error() string {
 	return fmt.Sprintf("BlockArt: Invalid block hash [%s]", string(e))
 }
 
+// Contains the hash of the block that added the shape before it left the
+// longest chain.
+type ShapeOrphanedError string
+
+func (e ShapeOrphanedError) Error() string {
+	return fmt.Sprintf("BlockArt: Shape was in a block that left the longest chain [%s]", string(e))
+}
+
+// Contains the offending fill or stroke colour.
+type InvalidColourError string
+
+func (e InvalidColourError) Error() string {
+	return fmt.Sprintf("BlockArt: Bad colour [%s]", string(e))
+}
+
 // Contains the invalid miner's private/public key
 type InvalidMinerPKError string
 
@@ -170,8 +226,57 @@ type Canvas interface {
 	// - ShapeSvgStringTooLongError
 	// - ShapeOverlapError
 	// - OutOfBoundsError
+	// - InvalidColourError: fill and stroke must be transparent, a CSS
+	//   colour name, #rgb, #rrggbb or rgb(r, g, b)
 	AddShape(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error)
 
+	// Adds a new shape with an outline strokeWidth pixels wide. Ink cost
+	// and overlap are computed on the thick outline.
+	// Can return the same errors as AddShape.
+	AddShapeWithStroke(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+
+	// Adds a RECT, ELLIPSE, POLYGON or POLYLINE shape described by params.
+	// The shape costs the same ink as the path with the same outline, an
+	// ellipse is measured as a polygon through points of the ellipse.
+	// Can return the same errors as AddShape.
+	AddShapeWithParams(validateNum uint8, shapeType ShapeType, params ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+
+	// Checks a shape against the current canvas without adding it and
+	// without spending ink. params describe the shape as for
+	// AddShapeWithParams, shapeSvgString a PATH. Returns the ink it would cost, if it is inside
+	// the canvas and the hash of the first shape it would overlap.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidShapeSvgStringError
+	// - ShapeSvgStringTooLongError
+	// - InvalidColourError
+	EstimateShape(shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate ShapeEstimate, err error)
+
+	// Adds a batch of shapes in a single block, all of them or none, for
+	// the ink of all of them together. The batch is rejected if any shape
+	// overlaps another shape of the batch or of another art node.
+	// Returns the shape hashes in the order of the batch.
+	// Can return the same errors as AddShape.
+	AddShapes(validateNum uint8, shapes []BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error)
+
+	// Submits a new shape with an outline strokeWidth pixels wide and
+	// returns right away. params describe the shape as for
+	// AddShapeWithParams, shapeSvgString a PATH. The handle reports the progress of the shape
+	// until it is confirmed by validateNum blocks, orphaned or rejected.
+	// Rejected shapes carry the errors AddShape can return.
+	AddShapeAsync(validateNum uint8, shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) *ShapeHandle
+
+	// Streams every add, delete and move of a shape on the longest chain
+	// of the miner, starting with the ops already on it. When blocks leave
+	// the longest chain their events are sent again with Retracted set.
+	// When the canvas reconnects, maybe to another miner, an event of Kind
+	// "reset" is sent and the events start over from the first op on the
+	// chain of the new connection, so drop every shape seen until then.
+	// The channel is closed when ctx is done or no miner can be reached.
+	// Can return the following errors:
+	// - DisconnectedError
+	Watch(ctx context.Context) (events <-chan CanvasEvent, err error)
+
 	// Returns the encoding of the shape as an svg string.
 	// Can return the following errors:
 	// - DisconnectedError
@@ -189,6 +294,30 @@ type Canvas interface {
 	// - ShapeOwnerError
 	DeleteShape(validateNum uint8, shapeHash string) (inkRemaining uint32, err error)
 
+	// Moves a shape by dx, dy in one op. The moved shape may overlap where
+	// it was, it costs no ink and keeps its shape hash.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidShapeHashError
+	// - ShapeOwnerError
+	// - ShapeOverlapError
+	// - OutOfBoundsError
+	MoveShape(validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error)
+
+	// Returns the shapes on the canvas at the block, in the order they were
+	// added. An empty block hash means the tip of the longest chain.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidBlockHashError
+	GetCanvas(blockHash string) (shapes []CanvasShape, err error)
+
+	// Returns the header of the block, the ops it added and the ink its
+	// miner got for it.
+	// Can return the following errors:
+	// - DisconnectedError
+	// - InvalidBlockHashError
+	GetBlock(blockHash string) (block BlockInfo, err error)
+
 	// Retrieves hashes contained by a specific block.
 	// Can return the following errors:
 	// - DisconnectedError
@@ -206,9 +335,37 @@ type Canvas interface {
 	// - InvalidBlockHashError
 	GetChildren(blockHash string) (blockHashes []string, err error)
 
-	// Closes the canvas/connection to the BlockArt network.
+	// Sets the files CloseCanvas renders the canvas to, a standalone svg
+	// document and a png image. An empty path writes no file of that kind;
+	// without an svg file the svg document is printed to stdout.
+	SetOutput(svgPath string, pngPath string)
+
+	// Closes the canvas/connection to the BlockArt network and renders the
+	// canvas at the tip of the longest chain to the files set by SetOutput.
 	// - DisconnectedError
+	// - errors of writing the output files
 	CloseCanvas() (inkRemaining uint32, err error)
+
+	// Variants of the methods above that give up when ctx is done. They
+	// return the same results and errors, or ctx.Err() if ctx is done
+	// before the miner replied. Cancelling an add, delete or move tells
+	// the miner to stop waiting for confirmations; an op that was already
+	// put in a block stays on the chain.
+	AddShapeContext(ctx context.Context, validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+	AddShapeWithStrokeContext(ctx context.Context, validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+	AddShapeWithParamsContext(ctx context.Context, validateNum uint8, shapeType ShapeType, params ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error)
+	EstimateShapeContext(ctx context.Context, shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate ShapeEstimate, err error)
+	GetSvgStringContext(ctx context.Context, shapeHash string) (svgString string, err error)
+	GetInkContext(ctx context.Context) (inkRemaining uint32, err error)
+	DeleteShapeContext(ctx context.Context, validateNum uint8, shapeHash string) (inkRemaining uint32, err error)
+	MoveShapeContext(ctx context.Context, validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error)
+	GetShapesContext(ctx context.Context, blockHash string) (shapeHashes []string, err error)
+	GetGenesisBlockContext(ctx context.Context) (blockHash string, err error)
+	GetChildrenContext(ctx context.Context, blockHash string) (blockHashes []string, err error)
+	CloseCanvasContext(ctx context.Context) (inkRemaining uint32, err error)
+	AddShapesContext(ctx context.Context, validateNum uint8, shapes []BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error)
+	GetCanvasContext(ctx context.Context, blockHash string) (shapes []CanvasShape, err error)
+	GetBlockContext(ctx context.Context, blockHash string) (block BlockInfo, err error)
 }
 
 type AddShapeStruct struct {
@@ -218,6 +375,56 @@ type AddShapeStruct struct {
 	Fill           string
 	Stroke         string
 	ArtNodePK      string
+	StrokeWidth    uint32
+	Params         ShapeParams
+	RequestID      string // lets the request be cancelled while the miner waits
+}
+
+// One shape of a batch for AddShapes. ShapeSvgString is used for PATH,
+// Params for the other shape types.
+type BatchShape struct {
+	SType          ShapeType
+	ShapeSvgString string
+	Fill           string
+	Stroke         string
+	StrokeWidth    uint32
+	Params         ShapeParams
+}
+
+type AddShapesArgs struct {
+	ValidateNum uint8
+	Shapes      []BatchShape
+	ArtNodePK   string
+	RequestID   string
+}
+
+type AddShapesReply struct {
+	ShapeHashes  []string
+	BlockHash    string
+	InkRemaining uint32
+}
+
+// A point on the canvas.
+type Point struct {
+	X int
+	Y int
+}
+
+// Parameters of a RECT, ELLIPSE, POLYGON or POLYLINE shape.
+// Only the fields of the shape being drawn are used.
+type ShapeParams struct {
+	// RECT: top left corner, width and height
+	X      int
+	Y      int
+	Width  int
+	Height int
+	// ELLIPSE: centre and the two radii
+	Cx int
+	Cy int
+	Rx int
+	Ry int
+	// POLYGON and POLYLINE: vertices in drawing order, at most 64
+	Points []Point
 }
 
 type AddShapeReply struct {
@@ -226,23 +433,107 @@ type AddShapeReply struct {
 	InkRemaining uint32
 }
 
+// Result of EstimateShape.
+type ShapeEstimate struct {
+	// Ink needed to draw the shape, 0 if it is not in bounds
+	InkCost uint32
+	// If every pixel of the shape is on the canvas
+	InBounds bool
+	// Hash of the first shape of another art node it overlaps, "" if none
+	OverlapHash string
+}
+
 type DelShapeArgs struct {
 	ValidateNum uint8
 	ShapeHash   string
 	ArtNodePK   string
+	RequestID   string
+}
+
+type MoveShapeArgs struct {
+	ValidateNum uint8
+	ShapeHash   string
+	ArtNodePK   string
+	Dx          int
+	Dy          int
+	RequestID   string
+}
+
+type MoveShapeReply struct {
+	BlockHash    string
+	InkRemaining uint32
+}
+
+type ShapeStatusReply struct {
+	BlockHash string
+	Depth     int
+}
+
+// A shape on the canvas, see Canvas.GetCanvas.
+type CanvasShape struct {
+	ShapeHash   string
+	SvgString   string // svg element the shape is drawn with
+	Path        string // svg path with the geometry of the shape
+	Owner       string // key of the art node that owns the shape
+	Fill        string
+	Stroke      string
+	StrokeWidth int
+	InkCost     uint32
+	BlockHash   string // block that added the shape
+}
+
+// Header and ops of a block, see Canvas.GetBlock.
+type BlockInfo struct {
+	BlockHash  string
+	PrevHash   string
+	Nonce      uint32
+	Height     int    // number of blocks before it, 0 for the genesis block
+	MinerKey   string // key of the miner that mined the block
+	NoOpBlock  bool
+	Ops        []BlockOp // ops the block added, in order
+	InkAwarded uint32    // ink the miner got for the block
+}
+
+// An op of a block.
+type BlockOp struct {
+	Kind      string // "add", "delete" or "move"
+	ShapeHash string
+	Owner     string // key of the art node that made the op
+	InkCost   uint32 // ink of the shape for an add, 0 for delete and move
+}
+
+// A change of a shape on the longest chain, see Canvas.Watch.
+type CanvasEvent struct {
+	Seq       int    // position in the event log of the miner
+	Kind      string // "add", "delete" or "move", or "reset" after a reconnect
+	ShapeHash string
+	SvgString string // svg element of the shape, for add and move
+	Owner     string // key of the art node that owns the shape
+	BlockHash string // block with the op
+	Retracted bool   // the block left the longest chain, undo the event
+}
+
+type WatchArgs struct {
+	After int
+}
+
+type WatchReply struct {
+	Events []CanvasEvent
 }
 
 type CloseCanvReply struct {
-	canvOps      map[string][]string
-	inkRemaining uint32
+	Shapes       []CanvasShape
+	InkRemaining uint32
 }
 
 type Operation struct {
-	AppShape      string
-	OpSig         string
-	PubKeyArtNode string //key of the art node that generated the op
-	ShapeCommand  string // e.g. "M 0 0 L 0 3"
-	ShapeFill     string // fill or transparent
+	AppShape         string
+	OpSig            string
+	PubKeyArtNode    string //key of the art node that generated the op
+	ShapeCommand     string // e.g. "M 0 0 L 0 3"
+	ShapeFill        string // fill or transparent
+	ShapeStroke      string // stroke colour
+	ShapeStrokeWidth int    // stroke width in pixels
 }
 
 // The constructor for a new Canvas object instance. Takes the miner's
@@ -250,42 +541,77 @@ type Operation struct {
 // key type contains the public key). Returns a Canvas instance that
 // can be used for all future interactions with blockartlib.
 //
+// moreMinerAddrs are other miners that control the same miner key. The
+// canvas connects to the first miner it can reach and authenticate with.
+// When that miner goes away, calls in flight return DisconnectedError with
+// its address, and the next call reconnects with backoff, trying the other
+// miners too. Shapes stay owned by the canvas whichever miner it is
+// connected to.
+//
 // The returned Canvas instance is a singleton: an application is
 // expected to interact with just one Canvas instance at a time.
 //
 // Can return the following errors:
 // - DisconnectedError
-func OpenCanvas(minerAddr string, privKey ecdsa.PrivateKey) (canvas Canvas, setting CanvasSettings, err error) {
+func OpenCanvas(minerAddr string, privKey ecdsa.PrivateKey, moreMinerAddrs ...string) (canvas Canvas, setting CanvasSettings, err error) {
+	return OpenCanvasWithMiners(append([]string{minerAddr}, moreMinerAddrs...), privKey)
+}
 
-	c, err := rpc.Dial("tcp", minerAddr)
+// Like OpenCanvas, with the addresses of all the miners in one list.
+//
+// Can return the following errors:
+// - DisconnectedError
+func OpenCanvasWithMiners(minerAddrs []string, privKey ecdsa.PrivateKey) (canvas Canvas, setting CanvasSettings, err error) {
+	artnodePK, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
 	if err != nil {
-		return canvas, CanvasSettings{}, DisconnectedError("rpc dial")
+		return canvas, CanvasSettings{}, err
 	}
+	return OpenCanvasAs(minerAddrs, privKey, *artnodePK)
+}
 
-	artnodePK, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
-	var validMiner *ValidMiner
-	validMiner = &ValidMiner{}
-	privKeyInString := getPrivKeyInStr(privKey)
-	err = c.Call("InkMinerRPC.Connect", privKeyInString, &validMiner)
-
-	println("2", (*validMiner).Valid)
-	// return canvas, CanvasSettings{}, InvalidShapeSvgStringError("ss")
-	if !(*validMiner).Valid {
-		return canvas, CanvasSettings{}, DisconnectedError("invalid miner key")
+// Like OpenCanvasWithMiners, but the shapes are owned by artNodeKey instead
+// of a key made for this canvas only. Canvases opened with the same
+// artNodeKey, in this process or a later one, can delete and move each
+// other's shapes.
+//
+// Can return the following errors:
+// - DisconnectedError
+func OpenCanvasAs(minerAddrs []string, privKey ecdsa.PrivateKey, artNodeKey ecdsa.PrivateKey) (canvas Canvas, setting CanvasSettings, err error) {
+	if len(minerAddrs) == 0 {
+		return canvas, CanvasSettings{}, DisconnectedError("no miner address")
 	}
+	canv := &MyCanvas{minerAddrs: minerAddrs, minerPrivKey: privKey, artnodePrivKey: getPrivKeyInStr(artNodeKey)}
+	if _, _, err = canv.client(context.Background()); err != nil {
+		return canvas, CanvasSettings{}, err
+	}
+	return canv, canv.minerNetSettings.CanvasSettings, nil
+}
 
+// Dials the miner and authenticates with the miner key, giving up after
+// connectTimeout.
+// - DisconnectedError: if the miner can not be reached or rejects the key
+func connectMiner(minerAddr string, privKey ecdsa.PrivateKey) (*rpc.Client, MinerNetSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+	var dialer net.Dialer
+	netConn, err := dialer.DialContext(ctx, "tcp", minerAddr)
 	if err != nil {
-		return canvas, CanvasSettings{}, DisconnectedError("InkMinerRPC.Connect")
+		return nil, MinerNetSettings{}, DisconnectedError(minerAddr)
 	}
-	println("3")
-	tmp := validMiner.MinerNetSets
-	setting = tmp.canvasSettings
-	println("4")
-	artPkinStr := getPrivKeyInStr(*artnodePK)
-	canv := MyCanvas{c, privKey, validMiner.MinerNetSets, artPkinStr}
-
-	canvas = &canv
-	return canvas, setting, err
+	c := rpc.NewClient(netConn)
+	validMiner := &ValidMiner{}
+	call := c.Go("InkMinerRPC.Connect", getPrivKeyInStr(privKey), &validMiner, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		err = call.Error
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if err != nil || !validMiner.Valid {
+		c.Close()
+		return nil, MinerNetSettings{}, DisconnectedError(minerAddr)
+	}
+	return c, validMiner.MinerNetSets, nil
 }
 
 //======================================================================
@@ -300,13 +626,31 @@ func OpenCanvas(minerAddr string, privKey ecdsa.PrivateKey) (canvas Canvas, sett
 // - ShapeSvgStringTooLongError
 // - ShapeOverlapError
 // - OutOfBoundsError
+// - InvalidColourError
 func (c *MyCanvas) AddShape(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
-	if len(shapeSvgString) > 128 {
-		return "", "", 0, ShapeSvgStringTooLongError(shapeSvgString)
-	}
-	if stroke == fill && fill == "transparent" {
-		println("-----------------------------------------------------")
-		return "", "", 0, InvalidShapeSvgStringError("fill and stroke can't both be transparent")
+	return c.AddShapeWithStroke(validateNum, shapeType, shapeSvgString, fill, stroke, 1)
+}
+
+func (c *MyCanvas) AddShapeContext(ctx context.Context, validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeWithStrokeContext(ctx, validateNum, shapeType, shapeSvgString, fill, stroke, 1)
+}
+
+// Adds a new shape with an outline strokeWidth pixels wide.
+// Can return the following errors:
+// - DisconnectedError
+// - InsufficientInkError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - ShapeOverlapError
+// - OutOfBoundsError
+// - InvalidColourError
+func (c *MyCanvas) AddShapeWithStroke(validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeWithStrokeContext(context.Background(), validateNum, shapeType, shapeSvgString, fill, stroke, strokeWidth)
+}
+
+func (c *MyCanvas) AddShapeWithStrokeContext(ctx context.Context, validateNum uint8, shapeType ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if err := CheckShapeArgs(shapeType, shapeSvgString, ShapeParams{}, fill, stroke, strokeWidth, c.canvasSettings()); err != nil {
+		return "", "", 0, err
 	}
 	// err1 := validSvgCommand(shapeSvgString)
 	// if err1 != nil {
@@ -315,11 +659,151 @@ func (c *MyCanvas) AddShape(validateNum uint8, shapeType ShapeType, shapeSvgStri
 
 	// mpk := getPrivKeyInStr(c.minerPrivKey)
 
-	args := AddShapeStruct{1, shapeType, shapeSvgString, fill, stroke, c.artnodePrivKey}
+	args := AddShapeStruct{validateNum, shapeType, shapeSvgString, fill, stroke, c.artnodePrivKey, strokeWidth, ShapeParams{}, newRequestID()}
 	reply := AddShapeReply{}
-	err = c.conn.Call("InkMinerRPC.AddShape", args, &reply)
+	err = c.call(ctx, "InkMinerRPC.AddShape", args.RequestID, args, &reply)
 	// fmt.Println("@@@", reply.ShapeHash)
-	return reply.ShapeHash, reply.BlockHash, reply.InkRemaining, err
+	if err != nil {
+		return "", "", 0, err
+	}
+	return reply.ShapeHash, reply.BlockHash, reply.InkRemaining, nil
+}
+
+// Adds a RECT, ELLIPSE, POLYGON or POLYLINE shape described by params.
+// Can return the following errors:
+// - DisconnectedError
+// - InsufficientInkError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - ShapeOverlapError
+// - OutOfBoundsError
+// - InvalidColourError
+func (c *MyCanvas) AddShapeWithParams(validateNum uint8, shapeType ShapeType, params ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeWithParamsContext(context.Background(), validateNum, shapeType, params, fill, stroke, strokeWidth)
+}
+
+func (c *MyCanvas) AddShapeWithParamsContext(ctx context.Context, validateNum uint8, shapeType ShapeType, params ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if shapeType == PATH {
+		return "", "", 0, InvalidShapeSvgStringError("paths are added with AddShape")
+	}
+	if err := CheckShapeArgs(shapeType, "", params, fill, stroke, strokeWidth, c.canvasSettings()); err != nil {
+		return "", "", 0, err
+	}
+	args := AddShapeStruct{validateNum, shapeType, "", fill, stroke, c.artnodePrivKey, strokeWidth, params, newRequestID()}
+	reply := AddShapeReply{}
+	err = c.call(ctx, "InkMinerRPC.AddShape", args.RequestID, args, &reply)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return reply.ShapeHash, reply.BlockHash, reply.InkRemaining, nil
+}
+
+// Adds a batch of shapes in a single block.
+// Can return the following errors:
+// - DisconnectedError
+// - InsufficientInkError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - ShapeOverlapError
+// - OutOfBoundsError
+// - InvalidColourError
+func (c *MyCanvas) AddShapes(validateNum uint8, shapes []BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapesContext(context.Background(), validateNum, shapes)
+}
+
+func (c *MyCanvas) AddShapesContext(ctx context.Context, validateNum uint8, shapes []BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error) {
+	if len(shapes) == 0 {
+		return nil, "", 0, InvalidShapeSvgStringError("empty batch")
+	}
+	for _, s := range shapes {
+		if err := CheckShapeArgs(s.SType, s.ShapeSvgString, s.Params, s.Fill, s.Stroke, s.StrokeWidth, c.canvasSettings()); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	args := AddShapesArgs{validateNum, shapes, c.artnodePrivKey, newRequestID()}
+	reply := AddShapesReply{}
+	err = c.call(ctx, "InkMinerRPC.AddShapes", args.RequestID, args, &reply)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	return reply.ShapeHashes, reply.BlockHash, reply.InkRemaining, nil
+}
+
+// Checks a shape against the current canvas without adding it.
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - InvalidColourError
+func (c *MyCanvas) EstimateShape(shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate ShapeEstimate, err error) {
+	return c.EstimateShapeContext(context.Background(), shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+}
+
+func (c *MyCanvas) EstimateShapeContext(ctx context.Context, shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate ShapeEstimate, err error) {
+	if err := CheckShapeArgs(shapeType, shapeSvgString, params, fill, stroke, strokeWidth, c.canvasSettings()); err != nil {
+		return ShapeEstimate{}, err
+	}
+	args := AddShapeStruct{0, shapeType, shapeSvgString, fill, stroke, c.artnodePrivKey, strokeWidth, params, ""}
+	reply := ShapeEstimate{}
+	err = c.call(ctx, "InkMinerRPC.EstimateShape", "", args, &reply)
+	if err != nil {
+		return ShapeEstimate{}, err
+	}
+	return reply, nil
+}
+
+// Streams the changes of shapes on the longest chain.
+// Can return the following errors:
+// - DisconnectedError
+func (c *MyCanvas) Watch(ctx context.Context) (events <-chan CanvasEvent, err error) {
+	conn, _, err := c.client(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan CanvasEvent, 64)
+	go c.watch(ctx, conn, ch)
+	return ch, nil
+}
+
+// Asks conn for the events and sends them, and keeps asking for more. The
+// miner waits while there are none, so this is never done before Watch
+// returns. Seq is counted by each miner, so on a new connection the events
+// start over after a reset.
+func (c *MyCanvas) watch(ctx context.Context, conn *rpc.Client, ch chan<- CanvasEvent) {
+	defer close(ch)
+	after := -1
+	var events []CanvasEvent
+	for {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			case <-ctx.Done():
+				return
+			}
+			after = e.Seq
+		}
+		next, addr, err := c.client(ctx)
+		if err != nil {
+			return
+		}
+		if next != conn {
+			conn = next
+			events = []CanvasEvent{{Seq: -1, Kind: "reset"}}
+			continue
+		}
+		// the miner replies after a while even without new events
+		reply := WatchReply{}
+		err = c.callOn(ctx, conn, addr, "InkMinerRPC.WatchCanvas", "", WatchArgs{after}, &reply)
+		if _, lost := err.(DisconnectedError); lost {
+			// the next round reconnects
+			events = nil
+			continue
+		}
+		if err != nil {
+			return
+		}
+		events = reply.Events
+	}
 }
 
 // Returns the encoding of the shape as an svg string.
@@ -327,19 +811,33 @@ func (c *MyCanvas) AddShape(validateNum uint8, shapeType ShapeType, shapeSvgStri
 // - DisconnectedError
 // - InvalidShapeHashError
 func (c *MyCanvas) GetSvgString(shapeHash string) (svgString string, err error) {
+	return c.GetSvgStringContext(context.Background(), shapeHash)
+}
+
+func (c *MyCanvas) GetSvgStringContext(ctx context.Context, shapeHash string) (svgString string, err error) {
 	var reply string
-	err = c.conn.Call("InkMinerRPC.GetSvgString", shapeHash, &reply)
-	svgString = reply
-	return svgString, err
+	err = c.call(ctx, "InkMinerRPC.GetSvgString", "", shapeHash, &reply)
+	if err != nil {
+		return "", err
+	}
+	return reply, nil
 }
 
 // Returns the amount of ink currently available.
 // Can return the following errors:
 // - DisconnectedError
 func (c *MyCanvas) GetInk() (inkRemaining uint32, err error) {
+	return c.GetInkContext(context.Background())
+}
+
+func (c *MyCanvas) GetInkContext(ctx context.Context) (inkRemaining uint32, err error) {
 	mpk := getPrivKeyInStr(c.minerPrivKey)
-	err = c.conn.Call("InkMinerRPC.GetInk", mpk, &inkRemaining)
-	return inkRemaining, err
+	var reply uint32
+	err = c.call(ctx, "InkMinerRPC.GetInk", "", mpk, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply, nil
 }
 
 // Removes a shape from the canvas.
@@ -347,10 +845,73 @@ func (c *MyCanvas) GetInk() (inkRemaining uint32, err error) {
 // - DisconnectedError
 // - ShapeOwnerError
 func (c *MyCanvas) DeleteShape(validateNum uint8, shapeHash string) (inkRemaining uint32, err error) {
-	args := DelShapeArgs{validateNum, shapeHash, c.artnodePrivKey}
+	return c.DeleteShapeContext(context.Background(), validateNum, shapeHash)
+}
+
+func (c *MyCanvas) DeleteShapeContext(ctx context.Context, validateNum uint8, shapeHash string) (inkRemaining uint32, err error) {
+	args := DelShapeArgs{validateNum, shapeHash, c.artnodePrivKey, newRequestID()}
 	fmt.Print(args.ShapeHash, "lib!!!")
-	err = c.conn.Call("InkMinerRPC.DeleteShape", args, &inkRemaining)
-	return inkRemaining, err
+	var reply uint32
+	err = c.call(ctx, "InkMinerRPC.DeleteShape", args.RequestID, args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	return reply, nil
+}
+
+// Moves a shape by dx, dy.
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidShapeHashError
+// - ShapeOwnerError
+// - ShapeOverlapError
+// - OutOfBoundsError
+func (c *MyCanvas) MoveShape(validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error) {
+	return c.MoveShapeContext(context.Background(), validateNum, shapeHash, dx, dy)
+}
+
+func (c *MyCanvas) MoveShapeContext(ctx context.Context, validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error) {
+	args := MoveShapeArgs{validateNum, shapeHash, c.artnodePrivKey, dx, dy, newRequestID()}
+	reply := MoveShapeReply{}
+	err = c.call(ctx, "InkMinerRPC.MoveShape", args.RequestID, args, &reply)
+	if err != nil {
+		return "", 0, err
+	}
+	return reply.BlockHash, reply.InkRemaining, nil
+}
+
+// Returns the shapes on the canvas at the block, "" for the tip.
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func (c *MyCanvas) GetCanvas(blockHash string) (shapes []CanvasShape, err error) {
+	return c.GetCanvasContext(context.Background(), blockHash)
+}
+
+func (c *MyCanvas) GetCanvasContext(ctx context.Context, blockHash string) (shapes []CanvasShape, err error) {
+	var reply []CanvasShape
+	err = c.call(ctx, "InkMinerRPC.GetCanvas", "", blockHash, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+// Returns the header and ops of the block.
+// Can return the following errors:
+// - DisconnectedError
+// - InvalidBlockHashError
+func (c *MyCanvas) GetBlock(blockHash string) (block BlockInfo, err error) {
+	return c.GetBlockContext(context.Background(), blockHash)
+}
+
+func (c *MyCanvas) GetBlockContext(ctx context.Context, blockHash string) (block BlockInfo, err error) {
+	reply := BlockInfo{}
+	err = c.call(ctx, "InkMinerRPC.GetBlock", "", blockHash, &reply)
+	if err != nil {
+		return BlockInfo{}, err
+	}
+	return reply, nil
 }
 
 // Retrieves hashes contained by a specific block.
@@ -358,17 +919,33 @@ func (c *MyCanvas) DeleteShape(validateNum uint8, shapeHash string) (inkRemainin
 // - DisconnectedError
 // - InvalidBlockHashError
 func (c *MyCanvas) GetShapes(blockHash string) (shapeHashes []string, err error) {
-	err = c.conn.Call("InkMinerRPC.GetShapes", blockHash, &shapeHashes)
-	return shapeHashes, err
+	return c.GetShapesContext(context.Background(), blockHash)
+}
+
+func (c *MyCanvas) GetShapesContext(ctx context.Context, blockHash string) (shapeHashes []string, err error) {
+	var reply []string
+	err = c.call(ctx, "InkMinerRPC.GetShapes", "", blockHash, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
 }
 
 // Returns the block hash of the genesis block.
 // Can return the following errors:
 // - DisconnectedError
 func (c *MyCanvas) GetGenesisBlock() (blockHash string, err error) {
+	return c.GetGenesisBlockContext(context.Background())
+}
+
+func (c *MyCanvas) GetGenesisBlockContext(ctx context.Context) (blockHash string, err error) {
 	arg := 0
-	err = c.conn.Call("InkMinerRPC.GetGenesisBlock", arg, &blockHash)
-	return blockHash, err
+	var reply string
+	err = c.call(ctx, "InkMinerRPC.GetGenesisBlock", "", arg, &reply)
+	if err != nil {
+		return "", err
+	}
+	return reply, nil
 }
 
 // Retrieves the children blocks of the block identified by blockHash.
@@ -376,47 +953,294 @@ func (c *MyCanvas) GetGenesisBlock() (blockHash string, err error) {
 // - DisconnectedError
 // - InvalidBlockHashError
 func (c *MyCanvas) GetChildren(blockHash string) (blockHashes []string, err error) {
+	return c.GetChildrenContext(context.Background(), blockHash)
+}
+
+func (c *MyCanvas) GetChildrenContext(ctx context.Context, blockHash string) (blockHashes []string, err error) {
+	var reply []string
+	err = c.call(ctx, "InkMinerRPC.GetChildren", "", blockHash, &reply)
+	if err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
 
-	err = c.conn.Call("InkMinerRPC.GetChildren", blockHash, &blockHashes)
-	return blockHashes, err
+// Sets the files CloseCanvas renders the canvas to, a standalone svg
+// document and a png image. An empty path writes no file of that kind;
+// without an svg file the svg document is printed to stdout.
+func (c *MyCanvas) SetOutput(svgPath string, pngPath string) {
+	c.Lock()
+	defer c.Unlock()
+	c.svgPath, c.pngPath = svgPath, pngPath
 }
 
-// Closes the canvas/connection to the BlockArt network.
+// Closes the canvas/connection to the BlockArt network and renders the
+// canvas at the tip of the longest chain to the files set by SetOutput.
 // - DisconnectedError
+// - errors of writing the output files
 func (c *MyCanvas) CloseCanvas() (inkRemaining uint32, err error) {
+	return c.CloseCanvasContext(context.Background())
+}
+
+func (c *MyCanvas) CloseCanvasContext(ctx context.Context) (inkRemaining uint32, err error) {
 	args := 0
-	var reply *CloseCanvReply
-	err = c.conn.Call("InkMinerRPC.CloseCanvas", args, &reply)
-	ops := (*reply).canvOps
-	tmpMap := make(map[string]string)
-	html := "<HTML>	<HEAD>	   <TITLE>A Small Hello	   </TITLE>	</HEAD>  <BODY>	<H1>Hi</H1>  <svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" height=\"190\">"
-	for _, elem := range ops {
-		for k := 0; k < len(elem); k++ {
-			strs := strings.Split(elem[k], ":")
-			svgString := strs[0]
-			shapehash := strs[1]
-			if svgString != "delete" {
-				tmpMap[shapehash] = svgString
-			} else if svgString == "delete" {
-				delete(tmpMap, shapehash)
-			}
-		}
+	reply := CloseCanvReply{}
+	err = c.call(ctx, "InkMinerRPC.CloseCanvas", "", args, &reply)
+	if err != nil {
+		return 0, err
+	}
+	c.Lock()
+	svgPath, pngPath := c.svgPath, c.pngPath
+	canvas := SvgHelper.CanvasSettings(c.minerNetSettings.CanvasSettings)
+	c.Unlock()
+	shapes := RenderShapes(reply.Shapes)
+	if svgPath == "" {
+		fmt.Print(render.SVG(shapes, canvas))
+	} else if err = render.WriteSVG(svgPath, shapes, canvas); err != nil {
+		return reply.InkRemaining, err
+	}
+	if pngPath != "" {
+		err = render.WritePNG(pngPath, shapes, canvas)
 	}
+	return reply.InkRemaining, err
+}
 
-	for _, val := range tmpMap {
-		html = html + val
+// Returns the shapes of a canvas snapshot, as from GetCanvas, in the form
+// the render package draws.
+func RenderShapes(shapes []CanvasShape) []render.Shape {
+	rendered := make([]render.Shape, len(shapes))
+	for i, shape := range shapes {
+		rendered[i] = render.Shape{SvgString: shape.SvgString, Path: shape.Path, Fill: shape.Fill,
+			Stroke: shape.Stroke, StrokeWidth: shape.StrokeWidth}
+	}
+	return rendered
+}
+
+// Checks of a shape that do not need the miner: the ones AddShape and its
+// variants make before the shape is sent. The svg string is only checked
+// for PATH, params only for the other shape types. The stroke can be as wide
+// as the diagonal of the canvas.
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - InvalidColourError
+func CheckShapeArgs(shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32, canvas CanvasSettings) error {
+	if shapeType == PATH && len(shapeSvgString) > 128 {
+		return ShapeSvgStringTooLongError(shapeSvgString)
 	}
-	html = html + "</svg>	<P>This is very minimal \"hello world\" HTML documen.</P>  </BODY> </HTML>"
+	if len(params.Points) > 64 {
+		return ShapeSvgStringTooLongError("more than 64 points")
+	}
+	if SvgHelper.IsTransparent(fill) && SvgHelper.IsTransparent(stroke) {
+		return InvalidShapeSvgStringError("fill and stroke can't both be transparent")
+	}
+	if strokeWidth < 1 {
+		return InvalidShapeSvgStringError("stroke width must be at least 1")
+	}
+	if int64(strokeWidth) > int64(SvgHelper.MaxStrokeWidth(SvgHelper.CanvasSettings(canvas))) {
+		return InvalidShapeSvgStringError("stroke width wider than the canvas")
+	}
+	_, _, err := ShapeElement(shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+	return err
+}
 
-	fmt.Println(html)
-	inkRemaining = (*reply).inkRemaining
-	return inkRemaining, err
+// Returns the svg path with the geometry of the shape and the svg element
+// it is drawn with, as the miner builds them.
+// - InvalidShapeSvgStringError
+// - ShapeSvgStringTooLongError
+// - InvalidColourError
+func ShapeElement(shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) (path string, element string, err error) {
+	p := SvgHelper.ShapeParams{X: params.X, Y: params.Y, Width: params.Width, Height: params.Height,
+		Cx: params.Cx, Cy: params.Cy, Rx: params.Rx, Ry: params.Ry}
+	for _, point := range params.Points {
+		p.Points = append(p.Points, SvgHelper.Vertex{X: point.X, Y: point.Y})
+	}
+	path, element, err = SvgHelper.ShapeElement(int(shapeType), shapeSvgString, p, fill, stroke, int(strokeWidth))
+	switch e := err.(type) {
+	case SvgHelper.InvalidShapeSvgStringError:
+		err = InvalidShapeSvgStringError(e)
+	case SvgHelper.ShapeSvgStringTooLongError:
+		err = ShapeSvgStringTooLongError(e)
+	case SvgHelper.InvalidColourError:
+		err = InvalidColourError(e)
+	}
+	return path, element, err
 }
 
 //======================================================================
 //helper functions
 //======================================================================
 
+// Size of the canvas, as the miner last connected to reported it.
+func (c *MyCanvas) canvasSettings() CanvasSettings {
+	c.Lock()
+	defer c.Unlock()
+	return c.minerNetSettings.CanvasSettings
+}
+
+// Makes the rpc call to the miner and waits for the reply or for ctx to be
+// done, whichever comes first. If ctx is done first, the miner is told to
+// stop waiting for confirmations of the request with the given id, and
+// ctx.Err() is returned. reply must not be read after that.
+// Errors other than those returned by the miner mean the connection is
+// lost; they are returned as DisconnectedError with the miner address.
+func (c *MyCanvas) call(ctx context.Context, method string, requestID string, args interface{}, reply interface{}) error {
+	conn, addr, err := c.client(ctx)
+	if err != nil {
+		return err
+	}
+	return c.callOn(ctx, conn, addr, method, requestID, args, reply)
+}
+
+// Like call, on the connection to the miner at addr that client returned.
+func (c *MyCanvas) callOn(ctx context.Context, conn *rpc.Client, addr string, method string, requestID string, args interface{}, reply interface{}) error {
+	call := conn.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if serverErr, fromMiner := call.Error.(rpc.ServerError); fromMiner {
+			return decodeError(serverErr)
+		}
+		if call.Error != nil {
+			c.dropClient(conn)
+			return DisconnectedError(addr)
+		}
+		return nil
+	case <-ctx.Done():
+		if requestID != "" {
+			conn.Go("InkMinerRPC.CancelRequest", requestID, new(bool), make(chan *rpc.Call, 1))
+		}
+		return ctx.Err()
+	}
+}
+
+// Error envelope the miner sends as the text of an RPC error.
+type errorEnvelope struct {
+	Code    string
+	Payload string
+	Message string
+}
+
+// Rebuilds the typed error the miner returned from its envelope. Errors
+// without a known code are returned with the message of the miner.
+func decodeError(err rpc.ServerError) error {
+	var e errorEnvelope
+	if json.Unmarshal([]byte(err), &e) != nil {
+		return err
+	}
+	switch e.Code {
+	case "InsufficientInk":
+		ink, _ := strconv.ParseUint(e.Payload, 10, 32)
+		return InsufficientInkError(ink)
+	case "OutOfBounds":
+		var bounds OutOfBoundsError
+		fmt.Sscanf(e.Payload, "%d,%d", &bounds.X, &bounds.Y)
+		return bounds
+	case "ShapeOverlap":
+		return ShapeOverlapError(e.Payload)
+	case "ShapeOwner":
+		return ShapeOwnerError(e.Payload)
+	case "InvalidShapeSvgString":
+		return InvalidShapeSvgStringError(e.Payload)
+	case "ShapeSvgStringTooLong":
+		return ShapeSvgStringTooLongError(e.Payload)
+	case "InvalidColour":
+		return InvalidColourError(e.Payload)
+	case "InvalidShapeHash":
+		return InvalidShapeHashError(e.Payload)
+	case "InvalidBlockHash":
+		return InvalidBlockHashError(e.Payload)
+	case "InvalidMinerPK":
+		return InvalidMinerPKError(e.Payload)
+	}
+	return rpc.ServerError(e.Message)
+}
+
+// Returns the connection to the current miner, connecting first if there
+// is none. One goroutine reconnects for all callers, which wait for it
+// until their ctx is done.
+// - DisconnectedError: with the last address tried if no miner accepts
+func (c *MyCanvas) client(ctx context.Context) (*rpc.Client, string, error) {
+	c.Lock()
+	if c.conn != nil {
+		defer c.Unlock()
+		return c.conn, c.minerAddrs[c.current], nil
+	}
+	reconnected := c.reconnected
+	if reconnected == nil {
+		reconnected = make(chan struct{})
+		c.reconnected = reconnected
+		go c.reconnect(reconnected)
+	}
+	c.Unlock()
+	select {
+	case <-reconnected:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return nil, "", c.connErr
+	}
+	return c.conn, c.minerAddrs[c.current], nil
+}
+
+// Tries every miner address in turn, starting with the current one, backing
+// off between rounds, then closes reconnected.
+func (c *MyCanvas) reconnect(reconnected chan struct{}) {
+	c.Lock()
+	start := c.current
+	c.Unlock()
+	var err error
+	backoff := reconnectMinBackoff
+	for round := 0; round < reconnectRounds; round++ {
+		if round > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+			if backoff > reconnectMaxBackoff {
+				backoff = reconnectMaxBackoff
+			}
+		}
+		for k := 0; k < len(c.minerAddrs); k++ {
+			i := (start + k) % len(c.minerAddrs)
+			var conn *rpc.Client
+			var settings MinerNetSettings
+			conn, settings, err = connectMiner(c.minerAddrs[i], c.minerPrivKey)
+			if err == nil {
+				c.Lock()
+				c.conn, c.current, c.minerNetSettings = conn, i, settings
+				c.reconnected = nil
+				c.Unlock()
+				close(reconnected)
+				return
+			}
+		}
+	}
+	c.Lock()
+	c.connErr = err
+	c.reconnected = nil
+	c.Unlock()
+	close(reconnected)
+}
+
+// Forgets the broken connection so the next call reconnects. Another call
+// may already have replaced it.
+func (c *MyCanvas) dropClient(conn *rpc.Client) {
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == conn {
+		conn.Close()
+		c.conn = nil
+	}
+}
+
+// Returns a random id for a request that makes the miner wait for
+// confirmations, so the request can be cancelled.
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
 func exitOnError(prefix string, err error) {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "%s, err = %s\n", prefix, err.Error())
@@ -0,0 +1,535 @@
+package blockartlib
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/rpc"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// In-process miner serving InkMinerRPC on 127.0.0.1 for the tests. Its
+// methods reply with the fields of the miner and record the args they got.
+type testMiner struct {
+	sync.Mutex
+	listener net.Listener
+	conns    []net.Conn
+	closed   chan struct{}
+
+	addArgs   []AddShapeStruct // args of every AddShape call
+	addReply  AddShapeReply
+	addErr    error
+	addWait   chan struct{} // if set, AddShape replies once it is closed
+	cancelled chan string   // if set, gets the id of every CancelRequest call
+	depth     int           // depth of the shape of addReply, one more on every GetShapeStatus
+	statusErr error
+	events    []CanvasEvent // event log WatchCanvas replies from
+}
+
+func startTestMiner(t *testing.T) *testMiner {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serveTestMiner(t, l)
+}
+
+// Starts a miner accepting art nodes on l.
+func serveTestMiner(t *testing.T, l net.Listener) *testMiner {
+	m := &testMiner{listener: l, closed: make(chan struct{})}
+	server := rpc.NewServer()
+	server.RegisterName("InkMinerRPC", &testMinerRPC{m})
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			m.Lock()
+			m.conns = append(m.conns, conn)
+			m.Unlock()
+			go server.ServeConn(conn)
+		}
+	}()
+	t.Cleanup(m.close)
+	return m
+}
+
+func (m *testMiner) addr() string {
+	return m.listener.Addr().String()
+}
+
+// Stops the miner and drops the connections of its art nodes.
+func (m *testMiner) close() {
+	m.listener.Close()
+	m.Lock()
+	defer m.Unlock()
+	select {
+	case <-m.closed:
+		return
+	default:
+		close(m.closed)
+	}
+	for _, conn := range m.conns {
+		conn.Close()
+	}
+}
+
+type testMinerRPC struct {
+	m *testMiner
+}
+
+func (r *testMinerRPC) Connect(minerPrivKey string, reply *ValidMiner) error {
+	*reply = ValidMiner{MinerNetSettings{CanvasSettings: CanvasSettings{100, 100}}, true}
+	return nil
+}
+
+func (r *testMinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) error {
+	r.m.Lock()
+	r.m.addArgs = append(r.m.addArgs, args)
+	wait := r.m.addWait
+	r.m.Unlock()
+	if wait != nil {
+		<-wait
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	*reply = r.m.addReply
+	return r.m.addErr
+}
+
+func (r *testMinerRPC) GetShapeStatus(shapeHash string, reply *ShapeStatusReply) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.m.statusErr != nil {
+		return r.m.statusErr
+	}
+	r.m.depth++
+	*reply = ShapeStatusReply{r.m.addReply.BlockHash, r.m.depth}
+	return nil
+}
+
+// Replies with the events after args.After. Like the miner, it waits while
+// there are none; events are not added later, so until the miner stops.
+func (r *testMinerRPC) WatchCanvas(args WatchArgs, reply *WatchReply) error {
+	r.m.Lock()
+	for _, e := range r.m.events {
+		if e.Seq > args.After {
+			reply.Events = append(reply.Events, e)
+		}
+	}
+	r.m.Unlock()
+	if len(reply.Events) == 0 {
+		<-r.m.closed
+	}
+	return nil
+}
+
+func (r *testMinerRPC) CancelRequest(requestID string, reply *bool) error {
+	if r.m.cancelled != nil {
+		r.m.cancelled <- requestID
+	}
+	*reply = true
+	return nil
+}
+
+// The error the miner returns for a typed error: its envelope as text.
+func envelope(code string, payload string, message string) error {
+	text, _ := json.Marshal(errorEnvelope{code, payload, message})
+	return errors.New(string(text))
+}
+
+// Opens a canvas on the miners with new keys.
+func openTestCanvas(t *testing.T, miners ...*testMiner) *MyCanvas {
+	minerKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artNodeKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := make([]string, len(miners))
+	for i, m := range miners {
+		addrs[i] = m.addr()
+	}
+	canvas, _, err := OpenCanvasAs(addrs, *minerKey, *artNodeKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return canvas.(*MyCanvas)
+}
+
+func TestDecodeError(t *testing.T) {
+	m := startTestMiner(t)
+	canvas := openTestCanvas(t, m)
+	tests := []struct {
+		sent     error
+		expected error
+	}{
+		// as the miner's envelopeError sends InsufficientInkError(100)
+		{errors.New(`{"Code":"InsufficientInk","Payload":"100","Message":"BlockArt: Not enough ink to addShape [100]"}`),
+			InsufficientInkError(100)},
+		{envelope("OutOfBounds", "3,-1", ""), OutOfBoundsError{3, -1}},
+		{envelope("ShapeOverlap", "abc", ""), ShapeOverlapError("abc")},
+		{envelope("ShapeOwner", "abc", ""), ShapeOwnerError("abc")},
+		{envelope("InvalidShapeSvgString", "M", ""), InvalidShapeSvgStringError("M")},
+		{envelope("ShapeSvgStringTooLong", "M", ""), ShapeSvgStringTooLongError("M")},
+		{envelope("InvalidColour", "x", ""), InvalidColourError("x")},
+		{envelope("", "", "Miner has no blocks yet"), rpc.ServerError("Miner has no blocks yet")},
+		{errors.New("not an envelope"), rpc.ServerError("not an envelope")},
+	}
+	for _, test := range tests {
+		m.Lock()
+		m.addErr = test.sent
+		m.Unlock()
+		_, _, _, err := canvas.AddShape(0, PATH, "M 0 0 L 0 5", "transparent", "red")
+		if err != test.expected {
+			t.Errorf("miner sent %v: got %#v, want %#v", test.sent, err, test.expected)
+		}
+	}
+}
+
+func TestAddShapeSendsValidateNum(t *testing.T) {
+	m := startTestMiner(t)
+	canvas := openTestCanvas(t, m)
+	canvas.AddShape(3, PATH, "M 0 0 L 0 5", "transparent", "red")
+	canvas.AddShapeContext(context.Background(), 4, PATH, "M 0 0 L 0 5", "transparent", "red")
+	canvas.AddShapeWithStroke(5, PATH, "M 0 0 L 0 5", "transparent", "red", 2)
+	canvas.AddShapeWithStrokeContext(context.Background(), 6, PATH, "M 0 0 L 0 5", "transparent", "red", 2)
+	canvas.AddShapeWithParamsContext(context.Background(), 7, RECT, ShapeParams{X: 1, Y: 1, Width: 2, Height: 2}, "transparent", "red", 1)
+	m.Lock()
+	defer m.Unlock()
+	if len(m.addArgs) != 5 {
+		t.Fatalf("miner got %d AddShape calls, want 5", len(m.addArgs))
+	}
+	for i, args := range m.addArgs {
+		if args.ValidateNum != uint8(3+i) {
+			t.Errorf("call %d: miner got validateNum %d, want %d", i, args.ValidateNum, 3+i)
+		}
+	}
+}
+
+// A call given up on tells the miner to stop waiting for its confirmations.
+func TestCancelSendsCancelRequest(t *testing.T) {
+	m := startTestMiner(t)
+	m.addWait, m.cancelled = make(chan struct{}), make(chan string, 1)
+	defer close(m.addWait)
+	canvas := openTestCanvas(t, m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, _, err := canvas.AddShapeContext(ctx, 3, PATH, "M 0 0 L 0 5", "transparent", "red"); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want DeadlineExceeded", err)
+	}
+	select {
+	case requestID := <-m.cancelled:
+		m.Lock()
+		sent := m.addArgs[0].RequestID
+		m.Unlock()
+		if requestID == "" || requestID != sent {
+			t.Errorf("miner got CancelRequest(%q), want the id %q of the AddShape call", requestID, sent)
+		}
+	case <-time.After(time.Second):
+		t.Error("miner got no CancelRequest")
+	}
+}
+
+func statusUpdates(h *ShapeHandle) []ShapeStatus {
+	var updates []ShapeStatus
+	for s := range h.Status {
+		updates = append(updates, s)
+	}
+	return updates
+}
+
+func TestAddShapeAsync(t *testing.T) {
+	defer func(interval time.Duration) { shapeStatusPollInterval = interval }(shapeStatusPollInterval)
+	shapeStatusPollInterval = time.Millisecond
+	m := startTestMiner(t)
+	m.addReply = AddShapeReply{"shape", "block", 90}
+	canvas := openTestCanvas(t, m)
+
+	h := canvas.AddShapeAsync(2, PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red", 1)
+	updates := statusUpdates(h)
+	expected := []ShapeStatus{
+		{State: ShapePending},
+		{State: ShapeIncluded, BlockHash: "block"},
+		{State: ShapeConfirmed, BlockHash: "block", Depth: 1},
+		{State: ShapeConfirmed, BlockHash: "block", Depth: 2},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("confirmed shape: got %v, want %v", updates, expected)
+	}
+	if shapeHash, blockHash, ink, err := h.Wait(); shapeHash != "shape" || blockHash != "block" || ink != 90 || err != nil {
+		t.Errorf("confirmed shape: Wait returned %s %s %d %v", shapeHash, blockHash, ink, err)
+	}
+	if m.addArgs[0].ValidateNum != 0 {
+		t.Errorf("miner got validateNum %d, want 0 as the handle waits itself", m.addArgs[0].ValidateNum)
+	}
+
+	m.Lock()
+	m.addErr = envelope("ShapeOverlap", "other", "")
+	m.Unlock()
+	h = canvas.AddShapeAsync(2, PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red", 1)
+	updates = statusUpdates(h)
+	expected = []ShapeStatus{{State: ShapePending}, {State: ShapeRejected, Err: ShapeOverlapError("other")}}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("shape rejected by the miner: got %v, want %v", updates, expected)
+	}
+	if _, _, _, err := h.Wait(); err != ShapeOverlapError("other") {
+		t.Errorf("shape rejected by the miner: Wait returned %v", err)
+	}
+
+	h = canvas.AddShapeAsync(2, PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "transparent", 1)
+	updates = statusUpdates(h)
+	if len(updates) != 1 || updates[0].State != ShapeRejected {
+		t.Errorf("invisible shape: got %v, want it rejected", updates)
+	}
+
+	m.Lock()
+	m.addErr, m.statusErr = nil, envelope("InvalidShapeHash", "shape", "")
+	m.Unlock()
+	h = canvas.AddShapeAsync(2, PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red", 1)
+	updates = statusUpdates(h)
+	expected = []ShapeStatus{
+		{State: ShapePending},
+		{State: ShapeIncluded, BlockHash: "block"},
+		{State: ShapeOrphaned, BlockHash: "block", Err: ShapeOrphanedError("block")},
+	}
+	if !reflect.DeepEqual(updates, expected) {
+		t.Errorf("orphaned shape: got %v, want %v", updates, expected)
+	}
+}
+
+// Seq of the events of the miner the canvas fails over to are its own,
+// the events must start over after a reset instead of skipping those up
+// to the last Seq of the first miner.
+func TestWatchFailover(t *testing.T) {
+	first, second := startTestMiner(t), startTestMiner(t)
+	first.events = []CanvasEvent{{Seq: 0, Kind: "add", ShapeHash: "a"}, {Seq: 1, Kind: "add", ShapeHash: "b"}}
+	second.events = []CanvasEvent{{Seq: 0, Kind: "add", ShapeHash: "a"}, {Seq: 1, Kind: "add", ShapeHash: "b"},
+		{Seq: 2, Kind: "add", ShapeHash: "c"}}
+	canvas := openTestCanvas(t, first, second)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, err := canvas.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	next := func() {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("events closed after %v", got)
+			}
+			got = append(got, e.Kind+" "+e.ShapeHash)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no event after %v", got)
+		}
+	}
+	next()
+	next()
+	first.close()
+	for i := 0; i < 4; i++ {
+		next()
+	}
+	expected := []string{"add a", "add b", "reset ", "add a", "add b", "add c"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %q, want %q", got, expected)
+	}
+	cancel()
+	for range events {
+	}
+}
+
+func TestFailover(t *testing.T) {
+	first, second := startTestMiner(t), startTestMiner(t)
+	canvas := openTestCanvas(t, first, second)
+	if _, _, _, err := canvas.AddShape(0, PATH, "M 0 0 L 0 5", "transparent", "red"); err != nil {
+		t.Fatal(err)
+	}
+	first.close()
+	// the call on the lost connection fails, the next one reconnects
+	_, _, _, err := canvas.AddShape(0, PATH, "M 0 0 L 0 5", "transparent", "red")
+	if err != DisconnectedError(first.addr()) {
+		t.Errorf("call on the closed miner: got %v, want %v", err, DisconnectedError(first.addr()))
+	}
+	if _, _, _, err := canvas.AddShape(0, PATH, "M 0 0 L 0 5", "transparent", "red"); err != nil {
+		t.Errorf("call after the miner closed: %v", err)
+	}
+	first.Lock()
+	onFirst := len(first.addArgs)
+	first.Unlock()
+	second.Lock()
+	onSecond := len(second.addArgs)
+	second.Unlock()
+	if onFirst != 1 || onSecond != 1 {
+		t.Errorf("got %d calls on the first miner and %d on the second, want 1 and 1", onFirst, onSecond)
+	}
+}
+
+func TestReconnectBackoff(t *testing.T) {
+	defer func(min time.Duration, max time.Duration) {
+		reconnectMinBackoff, reconnectMaxBackoff = min, max
+	}(reconnectMinBackoff, reconnectMaxBackoff)
+	reconnectMinBackoff, reconnectMaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+	first, second := startTestMiner(t), startTestMiner(t)
+	canvas := openTestCanvas(t, first, second)
+	first.close()
+	second.close()
+	canvas.GetGenesisBlock() // finds the connection lost
+
+	start := time.Now()
+	_, err := canvas.GetGenesisBlock()
+	// one round of connects, then waits of 10ms, 20ms, 20ms and 20ms
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("gave up after %v, want at least 70ms of backoff", elapsed)
+	}
+	if _, lost := err.(DisconnectedError); !lost {
+		t.Errorf("no miner up: got %v, want a DisconnectedError", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := canvas.GetGenesisBlockContext(ctx); err != context.Canceled {
+		t.Errorf("cancelled while backing off: got %v, want %v", err, context.Canceled)
+	}
+	canvas.GetGenesisBlock() // waits for the reconnect the cancelled call started
+
+	// the miner comes back while the canvas backs off
+	reconnectMinBackoff, reconnectMaxBackoff = 50*time.Millisecond, 50*time.Millisecond
+	restarted := make(chan *testMiner, 1)
+	listenErr := make(chan error, 1)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		l, err := net.Listen("tcp", second.addr())
+		if err != nil {
+			listenErr <- err
+			return
+		}
+		restarted <- serveTestMiner(t, l)
+	}()
+	_, _, _, err = canvas.AddShape(0, PATH, "M 0 0 L 0 5", "transparent", "red")
+	var m *testMiner
+	select {
+	case m = <-restarted:
+	case err := <-listenErr:
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Errorf("miner back during backoff: %v", err)
+	}
+	m.Lock()
+	defer m.Unlock()
+	if len(m.addArgs) != 1 {
+		t.Errorf("restarted miner got %d AddShape calls, want 1", len(m.addArgs))
+	}
+}
+
+// A reconnect that takes long does not hold up calls whose ctx is done.
+func TestReconnectHonoursContext(t *testing.T) {
+	defer func(timeout time.Duration, min time.Duration) {
+		connectTimeout, reconnectMinBackoff = timeout, min
+	}(connectTimeout, reconnectMinBackoff)
+	connectTimeout, reconnectMinBackoff = 100*time.Millisecond, time.Millisecond
+	// accepts connections and never answers on them
+	silent, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	go func() {
+		for {
+			conn, err := silent.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	m := startTestMiner(t)
+	minerKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	opened, _, err := OpenCanvas(m.addr(), *minerKey, silent.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	canvas := opened.(*MyCanvas)
+	m.close()
+	canvas.GetGenesisBlock() // finds the connection lost
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			_, err := canvas.GetGenesisBlockContext(ctx)
+			if elapsed := time.Since(start); err != context.DeadlineExceeded || elapsed > 80*time.Millisecond {
+				t.Errorf("call with a 20ms deadline: got %v after %v", err, elapsed)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := canvas.GetGenesisBlock(); err != DisconnectedError(silent.Addr().String()) {
+		t.Errorf("no miner answering: got %v, want %v", err, DisconnectedError(silent.Addr().String()))
+	}
+}
+
+// The miner waits for events while the canvas has none, Watch does not.
+func TestWatchEmptyCanvas(t *testing.T) {
+	m := startTestMiner(t)
+	canvas := openTestCanvas(t, m)
+	ctx, cancel := context.WithCancel(context.Background())
+	returned := make(chan (<-chan CanvasEvent))
+	go func() {
+		events, err := canvas.Watch(ctx)
+		if err != nil {
+			t.Error(err)
+		}
+		returned <- events
+	}()
+	var events <-chan CanvasEvent
+	select {
+	case events = <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("Watch waited for the first events")
+	}
+	cancel()
+	m.close() // ends the WatchCanvas call in flight
+	for e := range events {
+		t.Errorf("got event %v from an empty canvas", e)
+	}
+}
+
+func TestCheckShapeArgsTransparent(t *testing.T) {
+	err := CheckShapeArgs(PATH, "M 0 0 L 0 5", ShapeParams{}, "Transparent", "TRANSPARENT", 1, CanvasSettings{100, 100})
+	if _, invalid := err.(InvalidShapeSvgStringError); !invalid {
+		t.Errorf("invisible shape: got %v, want an InvalidShapeSvgStringError", err)
+	}
+}
+
+// The stroke of a 100x100 canvas is at most its diagonal, 141 pixels wide.
+func TestCheckShapeArgsStrokeWidth(t *testing.T) {
+	canvas := CanvasSettings{100, 100}
+	if err := CheckShapeArgs(PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red", 141, canvas); err != nil {
+		t.Errorf("width 141: got %v, want no error", err)
+	}
+	for _, width := range []uint32{142, math.MaxUint32} {
+		err := CheckShapeArgs(PATH, "M 0 0 L 0 5", ShapeParams{}, "transparent", "red", width, canvas)
+		if _, invalid := err.(InvalidShapeSvgStringError); !invalid {
+			t.Errorf("width %d: got %v, want an InvalidShapeSvgStringError", width, err)
+		}
+	}
+}
@@ -0,0 +1,739 @@
+// Package blockarttest provides an in-memory blockartlib.Canvas, so art
+// apps can be tested with go test without a server, ink miners or proof of
+// work. Shapes are checked with the same SvgHelper code the miners use, so
+// ink costs, bounds and overlaps are the ones the network would give.
+//
+// Every op goes into a block of its own on a single chain, followed by
+// validateNum no-op blocks that confirm it. Blocks are mined instantly
+// unless SetBlockInterval says otherwise.
+package blockarttest
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	".."
+	"../../SvgHelper"
+	"../../render"
+)
+
+// Key of the art node that owns the shapes added through the Canvas
+// methods. Shapes of other art nodes are added with AddShapeAs.
+const Owner = "blockarttest-artnode"
+
+// key of the miner reported for every block
+const minerKey = "blockarttest-miner"
+
+// Ink GetBlock reports the miner got for an op block and for a no-op
+// block, as in the server's example config.
+const (
+	InkPerOpBlock   = 100
+	InkPerNoOpBlock = 50
+)
+
+// A fake canvas. The zero value is not usable, use NewCanvas.
+type Canvas struct {
+	sync.Mutex                               // guards every field below
+	settings      blockartlib.CanvasSettings // size of the canvas
+	ink           uint32                     // ink of Owner
+	blockInterval time.Duration              // time to mine a block, 0 for instant
+	errs          map[string]error           // injected errors by method name
+	chain         []block                    // blocks from the genesis block to the tip
+	shapes        map[string]SvgHelper.Shape // geometry of the live shapes
+	index         *SvgHelper.ShapeIndex      // spatial index over shapes
+	live          map[string]shape           // live shapes by hash
+	order         []string                   // hashes of live shapes in the order they were added
+	elements      map[string]string          // svg element of every shape ever added
+	events        []blockartlib.CanvasEvent  // every add, delete and move, in order
+	changed       chan struct{}              // closed and replaced when events grows
+	seq           int                        // makes shape and block hashes unique
+	svgPath       string                     // files CloseCanvas renders the canvas to
+	pngPath       string
+}
+
+var _ blockartlib.Canvas = (*Canvas)(nil)
+
+type block struct {
+	info   blockartlib.BlockInfo
+	shapes []blockartlib.CanvasShape // shapes live after the block
+}
+
+// A live shape with what is needed to redraw it after a move.
+type shape struct {
+	blockartlib.CanvasShape
+	sType  blockartlib.ShapeType
+	params blockartlib.ShapeParams
+}
+
+// Returns an empty canvas of the given size, with ink for Owner to spend.
+func NewCanvas(settings blockartlib.CanvasSettings, ink uint32) *Canvas {
+	c := &Canvas{
+		settings: settings,
+		ink:      ink,
+		errs:     make(map[string]error),
+		shapes:   make(map[string]SvgHelper.Shape),
+		live:     make(map[string]shape),
+		elements: make(map[string]string),
+		changed:  make(chan struct{}),
+	}
+	c.index = SvgHelper.NewShapeIndex(c.shapes, c.canvas())
+	c.appendBlock(c.newBlockHash(), nil)
+	return c
+}
+
+// Sets the ink Owner has left.
+func (c *Canvas) SetInk(ink uint32) {
+	c.Lock()
+	defer c.Unlock()
+	c.ink = ink
+}
+
+// Sets how long each block takes to mine. Calls wait that long for every
+// confirmation they ask for. 0, the default, confirms ops instantly.
+func (c *Canvas) SetBlockInterval(interval time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.blockInterval = interval
+}
+
+// Makes every call of the method return err until it is set to nil again.
+// method is the name of a Canvas method without the Context suffix, for
+// example "AddShape"; the plain and Context variants both fail.
+func (c *Canvas) SetError(method string, err error) {
+	c.Lock()
+	defer c.Unlock()
+	if err == nil {
+		delete(c.errs, method)
+		return
+	}
+	c.errs[method] = err
+}
+
+// Adds a shape owned by another art node, in a block of its own. No ink is
+// charged for it. Useful to set up overlaps and ShapeOwnerErrors.
+// Can return the same errors as AddShapeWithStroke.
+func (c *Canvas) AddShapeAs(owner string, shapeType blockartlib.ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, err error) {
+	if err := blockartlib.CheckShapeArgs(shapeType, shapeSvgString, blockartlib.ShapeParams{}, fill, stroke, strokeWidth, c.settings); err != nil {
+		return "", err
+	}
+	c.Lock()
+	defer c.Unlock()
+	shapeHash, _, err = c.addShape(owner, shapeType, shapeSvgString, blockartlib.ShapeParams{}, fill, stroke, strokeWidth)
+	return shapeHash, err
+}
+
+func (c *Canvas) AddShape(validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeContext(context.Background(), validateNum, shapeType, shapeSvgString, fill, stroke)
+}
+
+func (c *Canvas) AddShapeContext(ctx context.Context, validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, fill string, stroke string) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "AddShape"); err != nil {
+		return "", "", 0, err
+	}
+	return c.addAndConfirm(ctx, validateNum, shapeType, shapeSvgString, blockartlib.ShapeParams{}, fill, stroke, 1)
+}
+
+func (c *Canvas) AddShapeWithStroke(validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeWithStrokeContext(context.Background(), validateNum, shapeType, shapeSvgString, fill, stroke, strokeWidth)
+}
+
+func (c *Canvas) AddShapeWithStrokeContext(ctx context.Context, validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "AddShapeWithStroke"); err != nil {
+		return "", "", 0, err
+	}
+	return c.addAndConfirm(ctx, validateNum, shapeType, shapeSvgString, blockartlib.ShapeParams{}, fill, stroke, strokeWidth)
+}
+
+func (c *Canvas) AddShapeWithParams(validateNum uint8, shapeType blockartlib.ShapeType, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapeWithParamsContext(context.Background(), validateNum, shapeType, params, fill, stroke, strokeWidth)
+}
+
+func (c *Canvas) AddShapeWithParamsContext(ctx context.Context, validateNum uint8, shapeType blockartlib.ShapeType, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "AddShapeWithParams"); err != nil {
+		return "", "", 0, err
+	}
+	if shapeType == blockartlib.PATH {
+		return "", "", 0, blockartlib.InvalidShapeSvgStringError("paths are added with AddShape")
+	}
+	return c.addAndConfirm(ctx, validateNum, shapeType, "", params, fill, stroke, strokeWidth)
+}
+
+func (c *Canvas) addAndConfirm(ctx context.Context, validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	if err := blockartlib.CheckShapeArgs(shapeType, shapeSvgString, params, fill, stroke, strokeWidth, c.settings); err != nil {
+		return "", "", 0, err
+	}
+	c.Lock()
+	shapeHash, blockHash, err = c.addShape(Owner, shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+	c.Unlock()
+	if err != nil {
+		return "", "", 0, err
+	}
+	if err := c.confirm(ctx, validateNum); err != nil {
+		return "", "", 0, err
+	}
+	return shapeHash, blockHash, c.inkRemaining(), nil
+}
+
+func (c *Canvas) EstimateShape(shapeType blockartlib.ShapeType, shapeSvgString string, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate blockartlib.ShapeEstimate, err error) {
+	return c.EstimateShapeContext(context.Background(), shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+}
+
+func (c *Canvas) EstimateShapeContext(ctx context.Context, shapeType blockartlib.ShapeType, shapeSvgString string, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (estimate blockartlib.ShapeEstimate, err error) {
+	if err := c.begin(ctx, "EstimateShape"); err != nil {
+		return blockartlib.ShapeEstimate{}, err
+	}
+	if err := blockartlib.CheckShapeArgs(shapeType, shapeSvgString, params, fill, stroke, strokeWidth, c.settings); err != nil {
+		return blockartlib.ShapeEstimate{}, err
+	}
+	path, _, err := blockartlib.ShapeElement(shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+	if err != nil {
+		return blockartlib.ShapeEstimate{}, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	e, err := SvgHelper.EstimateShape(path, Owner, fill, int(strokeWidth), c.canvas(), c.shapes, c.index)
+	if err != nil {
+		return blockartlib.ShapeEstimate{}, libError(err)
+	}
+	return blockartlib.ShapeEstimate{InkCost: e.InkCost, InBounds: e.InBounds, OverlapHash: e.OverlapHash}, nil
+}
+
+func (c *Canvas) AddShapes(validateNum uint8, shapes []blockartlib.BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error) {
+	return c.AddShapesContext(context.Background(), validateNum, shapes)
+}
+
+func (c *Canvas) AddShapesContext(ctx context.Context, validateNum uint8, shapes []blockartlib.BatchShape) (shapeHashes []string, blockHash string, inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "AddShapes"); err != nil {
+		return nil, "", 0, err
+	}
+	if len(shapes) == 0 {
+		return nil, "", 0, blockartlib.InvalidShapeSvgStringError("empty batch")
+	}
+	for _, s := range shapes {
+		if err := blockartlib.CheckShapeArgs(s.SType, s.ShapeSvgString, s.Params, s.Fill, s.Stroke, s.StrokeWidth, c.settings); err != nil {
+			return nil, "", 0, err
+		}
+	}
+
+	c.Lock()
+	batch := make([]SvgHelper.Shape, len(shapes))
+	added := make([]shape, len(shapes))
+	for i, s := range shapes {
+		path, element, err := blockartlib.ShapeElement(s.SType, s.ShapeSvgString, s.Params, s.Fill, s.Stroke, s.StrokeWidth)
+		if err != nil {
+			c.Unlock()
+			return nil, "", 0, err
+		}
+		hash := c.newShapeHash(element)
+		batch[i] = SvgHelper.Shape{ShapeHash: hash, PublicKey: Owner, SvgString: path, Fill: s.Fill,
+			StrokeWidth: int(s.StrokeWidth)}
+		added[i] = shape{blockartlib.CanvasShape{ShapeHash: hash, SvgString: element, Path: path, Owner: Owner,
+			Fill: s.Fill, Stroke: s.Stroke, StrokeWidth: int(s.StrokeWidth)}, s.SType, s.Params}
+	}
+	ink, err := SvgHelper.AddShapesToMap(batch, int(c.ink), c.canvas(), c.shapes, c.index)
+	if err != nil {
+		c.Unlock()
+		return nil, "", 0, libError(err)
+	}
+	c.ink -= uint32(ink)
+	blockHash = c.newBlockHash()
+	ops := make([]blockartlib.BlockOp, len(added))
+	for i, s := range added {
+		s.InkCost = shapeInk(batch[i], c.canvas())
+		s.BlockHash = blockHash
+		c.putShape(s)
+		ops[i] = blockartlib.BlockOp{Kind: "add", ShapeHash: s.ShapeHash, Owner: Owner, InkCost: s.InkCost}
+		shapeHashes = append(shapeHashes, s.ShapeHash)
+	}
+	c.appendBlock(blockHash, ops)
+	c.Unlock()
+
+	if err := c.confirm(ctx, validateNum); err != nil {
+		return nil, "", 0, err
+	}
+	return shapeHashes, blockHash, c.inkRemaining(), nil
+}
+
+// The handle reports the shape pending, included, then confirmed once per
+// block mined on top of it.
+func (c *Canvas) AddShapeAsync(validateNum uint8, shapeType blockartlib.ShapeType, shapeSvgString string, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) *blockartlib.ShapeHandle {
+	h, status, done := blockartlib.NewShapeHandle(int(validateNum) + 3)
+	go func() {
+		reject := func(err error) {
+			status <- blockartlib.ShapeStatus{State: blockartlib.ShapeRejected, Err: err}
+			done("", "", 0, err)
+		}
+		err := c.begin(context.Background(), "AddShapeAsync")
+		if err == nil {
+			err = blockartlib.CheckShapeArgs(shapeType, shapeSvgString, params, fill, stroke, strokeWidth, c.settings)
+		}
+		if err != nil {
+			reject(err)
+			return
+		}
+		status <- blockartlib.ShapeStatus{State: blockartlib.ShapePending}
+		c.Lock()
+		shapeHash, blockHash, err := c.addShape(Owner, shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+		c.Unlock()
+		if err != nil {
+			reject(err)
+			return
+		}
+		status <- blockartlib.ShapeStatus{State: blockartlib.ShapeIncluded, BlockHash: blockHash}
+		for depth := 1; depth <= int(validateNum); depth++ {
+			if err := c.confirm(context.Background(), 1); err != nil {
+				reject(err)
+				return
+			}
+			status <- blockartlib.ShapeStatus{State: blockartlib.ShapeConfirmed, BlockHash: blockHash, Depth: depth}
+		}
+		done(shapeHash, blockHash, c.inkRemaining(), nil)
+	}()
+	return h
+}
+
+// Sends every event so far, then every new one until ctx is done. There
+// are no reorgs, so no event is ever retracted.
+func (c *Canvas) Watch(ctx context.Context) (events <-chan blockartlib.CanvasEvent, err error) {
+	if err := c.begin(ctx, "Watch"); err != nil {
+		return nil, err
+	}
+	ch := make(chan blockartlib.CanvasEvent, 64)
+	go func() {
+		defer close(ch)
+		sent := 0
+		for {
+			c.Lock()
+			pending := c.events[sent:]
+			changed := c.changed
+			c.Unlock()
+			for _, e := range pending {
+				select {
+				case ch <- e:
+				case <-ctx.Done():
+					return
+				}
+				sent++
+			}
+			if len(pending) > 0 {
+				continue
+			}
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (c *Canvas) GetSvgString(shapeHash string) (svgString string, err error) {
+	return c.GetSvgStringContext(context.Background(), shapeHash)
+}
+
+func (c *Canvas) GetSvgStringContext(ctx context.Context, shapeHash string) (svgString string, err error) {
+	if err := c.begin(ctx, "GetSvgString"); err != nil {
+		return "", err
+	}
+	c.Lock()
+	defer c.Unlock()
+	element, exist := c.elements[shapeHash]
+	if !exist {
+		return "", blockartlib.InvalidShapeHashError(shapeHash)
+	}
+	return element, nil
+}
+
+func (c *Canvas) GetInk() (inkRemaining uint32, err error) {
+	return c.GetInkContext(context.Background())
+}
+
+func (c *Canvas) GetInkContext(ctx context.Context) (inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "GetInk"); err != nil {
+		return 0, err
+	}
+	return c.inkRemaining(), nil
+}
+
+func (c *Canvas) DeleteShape(validateNum uint8, shapeHash string) (inkRemaining uint32, err error) {
+	return c.DeleteShapeContext(context.Background(), validateNum, shapeHash)
+}
+
+func (c *Canvas) DeleteShapeContext(ctx context.Context, validateNum uint8, shapeHash string) (inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "DeleteShape"); err != nil {
+		return 0, err
+	}
+	c.Lock()
+	if _, exist := c.elements[shapeHash]; !exist {
+		c.Unlock()
+		return 0, blockartlib.InvalidShapeHashError(shapeHash)
+	}
+	ink, err := SvgHelper.RemoveShapeFromMap(shapeHash, Owner, c.canvas(), c.shapes, c.index)
+	if err != nil {
+		c.Unlock()
+		return 0, libError(err)
+	}
+	c.ink += uint32(ink)
+	delete(c.live, shapeHash)
+	for i, hash := range c.order {
+		if hash == shapeHash {
+			c.order = append(c.order[:i:i], c.order[i+1:]...)
+			break
+		}
+	}
+	blockHash := c.newBlockHash()
+	c.publish(blockartlib.CanvasEvent{Kind: "delete", ShapeHash: shapeHash, Owner: Owner, BlockHash: blockHash})
+	c.appendBlock(blockHash, []blockartlib.BlockOp{{Kind: "delete", ShapeHash: shapeHash, Owner: Owner}})
+	c.Unlock()
+
+	if err := c.confirm(ctx, validateNum); err != nil {
+		return 0, err
+	}
+	return c.inkRemaining(), nil
+}
+
+func (c *Canvas) MoveShape(validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error) {
+	return c.MoveShapeContext(context.Background(), validateNum, shapeHash, dx, dy)
+}
+
+func (c *Canvas) MoveShapeContext(ctx context.Context, validateNum uint8, shapeHash string, dx int, dy int) (blockHash string, inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "MoveShape"); err != nil {
+		return "", 0, err
+	}
+	c.Lock()
+	if _, exist := c.elements[shapeHash]; !exist {
+		c.Unlock()
+		return "", 0, blockartlib.InvalidShapeHashError(shapeHash)
+	}
+	path, err := SvgHelper.MoveShapeInMap(shapeHash, Owner, dx, dy, c.canvas(), c.shapes, c.index)
+	if err != nil {
+		c.Unlock()
+		return "", 0, libError(err)
+	}
+	s := c.live[shapeHash]
+	s.params = translateParams(s.params, dx, dy)
+	_, element, _ := blockartlib.ShapeElement(s.sType, path, s.params, s.Fill, s.Stroke, uint32(s.StrokeWidth))
+	s.SvgString, s.Path = element, path
+	c.live[shapeHash] = s
+	c.elements[shapeHash] = element
+	blockHash = c.newBlockHash()
+	c.publish(blockartlib.CanvasEvent{Kind: "move", ShapeHash: shapeHash, SvgString: element, Owner: Owner,
+		BlockHash: blockHash})
+	c.appendBlock(blockHash, []blockartlib.BlockOp{{Kind: "move", ShapeHash: shapeHash, Owner: Owner}})
+	c.Unlock()
+
+	if err := c.confirm(ctx, validateNum); err != nil {
+		return "", 0, err
+	}
+	return blockHash, c.inkRemaining(), nil
+}
+
+func (c *Canvas) GetCanvas(blockHash string) (shapes []blockartlib.CanvasShape, err error) {
+	return c.GetCanvasContext(context.Background(), blockHash)
+}
+
+func (c *Canvas) GetCanvasContext(ctx context.Context, blockHash string) (shapes []blockartlib.CanvasShape, err error) {
+	if err := c.begin(ctx, "GetCanvas"); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	i := len(c.chain) - 1
+	if blockHash != "" {
+		if i = c.blockIndex(blockHash); i < 0 {
+			return nil, blockartlib.InvalidBlockHashError(blockHash)
+		}
+	}
+	return append([]blockartlib.CanvasShape{}, c.chain[i].shapes...), nil
+}
+
+func (c *Canvas) GetBlock(blockHash string) (block blockartlib.BlockInfo, err error) {
+	return c.GetBlockContext(context.Background(), blockHash)
+}
+
+func (c *Canvas) GetBlockContext(ctx context.Context, blockHash string) (block blockartlib.BlockInfo, err error) {
+	if err := c.begin(ctx, "GetBlock"); err != nil {
+		return blockartlib.BlockInfo{}, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	i := c.blockIndex(blockHash)
+	if i < 0 {
+		return blockartlib.BlockInfo{}, blockartlib.InvalidBlockHashError(blockHash)
+	}
+	block = c.chain[i].info
+	block.Ops = append([]blockartlib.BlockOp{}, block.Ops...)
+	return block, nil
+}
+
+func (c *Canvas) GetShapes(blockHash string) (shapeHashes []string, err error) {
+	return c.GetShapesContext(context.Background(), blockHash)
+}
+
+func (c *Canvas) GetShapesContext(ctx context.Context, blockHash string) (shapeHashes []string, err error) {
+	if err := c.begin(ctx, "GetShapes"); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	i := c.blockIndex(blockHash)
+	if i < 0 {
+		return nil, blockartlib.InvalidBlockHashError(blockHash)
+	}
+	shapeHashes = []string{}
+	for _, op := range c.chain[i].info.Ops {
+		shapeHashes = append(shapeHashes, op.ShapeHash)
+	}
+	return shapeHashes, nil
+}
+
+func (c *Canvas) GetGenesisBlock() (blockHash string, err error) {
+	return c.GetGenesisBlockContext(context.Background())
+}
+
+func (c *Canvas) GetGenesisBlockContext(ctx context.Context) (blockHash string, err error) {
+	if err := c.begin(ctx, "GetGenesisBlock"); err != nil {
+		return "", err
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.chain[0].info.BlockHash, nil
+}
+
+func (c *Canvas) GetChildren(blockHash string) (blockHashes []string, err error) {
+	return c.GetChildrenContext(context.Background(), blockHash)
+}
+
+func (c *Canvas) GetChildrenContext(ctx context.Context, blockHash string) (blockHashes []string, err error) {
+	if err := c.begin(ctx, "GetChildren"); err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	i := c.blockIndex(blockHash)
+	if i < 0 {
+		return nil, blockartlib.InvalidBlockHashError(blockHash)
+	}
+	blockHashes = []string{}
+	if i+1 < len(c.chain) {
+		blockHashes = append(blockHashes, c.chain[i+1].info.BlockHash)
+	}
+	return blockHashes, nil
+}
+
+// Unlike the real canvas, nothing is printed when no svg file is set.
+func (c *Canvas) SetOutput(svgPath string, pngPath string) {
+	c.Lock()
+	defer c.Unlock()
+	c.svgPath, c.pngPath = svgPath, pngPath
+}
+
+func (c *Canvas) CloseCanvas() (inkRemaining uint32, err error) {
+	return c.CloseCanvasContext(context.Background())
+}
+
+func (c *Canvas) CloseCanvasContext(ctx context.Context) (inkRemaining uint32, err error) {
+	if err := c.begin(ctx, "CloseCanvas"); err != nil {
+		return 0, err
+	}
+	c.Lock()
+	shapes := blockartlib.RenderShapes(c.chain[len(c.chain)-1].shapes)
+	svgPath, pngPath, canvas, ink := c.svgPath, c.pngPath, c.canvas(), c.ink
+	c.Unlock()
+	if svgPath != "" {
+		if err := render.WriteSVG(svgPath, shapes, canvas); err != nil {
+			return ink, err
+		}
+	}
+	if pngPath != "" {
+		if err := render.WritePNG(pngPath, shapes, canvas); err != nil {
+			return ink, err
+		}
+	}
+	return ink, nil
+}
+
+//======================================================================
+//helper functions
+//======================================================================
+
+// Returns ctx.Err() if ctx is done, else the error injected for the method.
+func (c *Canvas) begin(ctx context.Context, method string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	return c.errs[method]
+}
+
+func (c *Canvas) inkRemaining() uint32 {
+	c.Lock()
+	defer c.Unlock()
+	return c.ink
+}
+
+func (c *Canvas) canvas() SvgHelper.CanvasSettings {
+	return SvgHelper.CanvasSettings(c.settings)
+}
+
+// Adds the shape of owner in a block of its own, charging owner's ink if
+// owner is Owner. c must be locked.
+func (c *Canvas) addShape(owner string, shapeType blockartlib.ShapeType, shapeSvgString string, params blockartlib.ShapeParams, fill string, stroke string, strokeWidth uint32) (shapeHash string, blockHash string, err error) {
+	path, element, err := blockartlib.ShapeElement(shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+	if err != nil {
+		return "", "", err
+	}
+	ink := math.MaxInt32
+	if owner == Owner {
+		ink = int(c.ink)
+	}
+	shapeHash = c.newShapeHash(element)
+	cost, err := SvgHelper.AddShapeToMap(shapeHash, path, owner, fill, int(strokeWidth), ink, c.canvas(), c.shapes, c.index)
+	if err != nil {
+		return "", "", libError(err)
+	}
+	if owner == Owner {
+		c.ink -= uint32(cost)
+	}
+	blockHash = c.newBlockHash()
+	c.putShape(shape{blockartlib.CanvasShape{ShapeHash: shapeHash, SvgString: element, Path: path, Owner: owner,
+		Fill: fill, Stroke: stroke, StrokeWidth: int(strokeWidth), InkCost: uint32(cost), BlockHash: blockHash},
+		shapeType, params})
+	c.appendBlock(blockHash, []blockartlib.BlockOp{{Kind: "add", ShapeHash: shapeHash, Owner: owner, InkCost: uint32(cost)}})
+	return shapeHash, blockHash, nil
+}
+
+// Makes the shape live and publishes its add event. c must be locked.
+func (c *Canvas) putShape(s shape) {
+	c.live[s.ShapeHash] = s
+	c.order = append(c.order, s.ShapeHash)
+	c.elements[s.ShapeHash] = s.SvgString
+	c.publish(blockartlib.CanvasEvent{Kind: "add", ShapeHash: s.ShapeHash, SvgString: s.SvgString, Owner: s.Owner,
+		BlockHash: s.BlockHash})
+}
+
+// Mines validateNum no-op blocks on top of the tip, waiting the block
+// interval before each one.
+// - ctx.Err(): if ctx is done first
+func (c *Canvas) confirm(ctx context.Context, validateNum uint8) error {
+	for i := 0; i < int(validateNum); i++ {
+		c.Lock()
+		interval := c.blockInterval
+		c.Unlock()
+		if interval > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		c.Lock()
+		c.appendBlock(c.newBlockHash(), nil)
+		c.Unlock()
+	}
+	return nil
+}
+
+// Appends a block with the ops, and the live shapes as its canvas, to the
+// chain. c must be locked.
+func (c *Canvas) appendBlock(blockHash string, ops []blockartlib.BlockOp) {
+	info := blockartlib.BlockInfo{BlockHash: blockHash, MinerKey: minerKey, NoOpBlock: len(ops) == 0, Ops: ops}
+	if n := len(c.chain); n > 0 {
+		info.PrevHash = c.chain[n-1].info.BlockHash
+		info.Height = c.chain[n-1].info.Height + 1
+		info.InkAwarded = InkPerOpBlock
+		if info.NoOpBlock {
+			info.InkAwarded = InkPerNoOpBlock
+		}
+	}
+	shapes := make([]blockartlib.CanvasShape, 0, len(c.order))
+	for _, hash := range c.order {
+		shapes = append(shapes, c.live[hash].CanvasShape)
+	}
+	c.chain = append(c.chain, block{info, shapes})
+}
+
+// Appends the event to the log and wakes up watchers. c must be locked.
+func (c *Canvas) publish(e blockartlib.CanvasEvent) {
+	e.Seq = len(c.events)
+	c.events = append(c.events, e)
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Canvas) blockIndex(blockHash string) int {
+	for i, b := range c.chain {
+		if b.info.BlockHash == blockHash {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Canvas) newShapeHash(element string) string {
+	c.seq++
+	return hashOf("shape", element, strconv.Itoa(c.seq))
+}
+
+func (c *Canvas) newBlockHash() string {
+	c.seq++
+	return hashOf("block", strconv.Itoa(c.seq))
+}
+
+func hashOf(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ink the shape cost when it was added
+func shapeInk(s SvgHelper.Shape, canvas SvgHelper.CanvasSettings) uint32 {
+	ink, _ := SvgHelper.InkCost(s.SvgString, s.Fill, s.StrokeWidth, canvas)
+	return uint32(ink)
+}
+
+func translateParams(params blockartlib.ShapeParams, dx int, dy int) blockartlib.ShapeParams {
+	params.X, params.Y = params.X+dx, params.Y+dy
+	params.Cx, params.Cy = params.Cx+dx, params.Cy+dy
+	points := make([]blockartlib.Point, len(params.Points))
+	for i, point := range params.Points {
+		points[i] = blockartlib.Point{X: point.X + dx, Y: point.Y + dy}
+	}
+	params.Points = points
+	return params
+}
+
+// Returns the blockartlib error an art node gets for the SvgHelper error.
+func libError(err error) error {
+	switch e := err.(type) {
+	case SvgHelper.InsufficientInkError:
+		return blockartlib.InsufficientInkError(e)
+	case SvgHelper.OutOfBoundsError:
+		return blockartlib.OutOfBoundsError{X: e.X, Y: e.Y}
+	case SvgHelper.ShapeOverlapError:
+		return blockartlib.ShapeOverlapError(e)
+	case SvgHelper.ShapeOwnerError:
+		return blockartlib.ShapeOwnerError(e)
+	case SvgHelper.InvalidShapeSvgStringError:
+		return blockartlib.InvalidShapeSvgStringError(e)
+	case SvgHelper.ShapeSvgStringTooLongError:
+		return blockartlib.ShapeSvgStringTooLongError(e)
+	case SvgHelper.InvalidColourError:
+		return blockartlib.InvalidColourError(e)
+	}
+	return err
+}
@@ -0,0 +1,205 @@
+package blockarttest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	".."
+)
+
+var testSettings = blockartlib.CanvasSettings{CanvasXMax: 100, CanvasYMax: 100}
+
+func TestAddAndDelete(t *testing.T) {
+	c := NewCanvas(testSettings, 100)
+	hash, blockHash, ink, err := c.AddShape(2, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red")
+	if err != nil || ink != 94 {
+		t.Fatal("Expected 94 ink left, got: ", ink, err)
+	}
+	children, _ := c.GetChildren(blockHash)
+	if len(children) != 1 {
+		t.Error("Expected the shape confirmed by a block, got: ", children)
+	}
+	shapes, _ := c.GetCanvas("")
+	if len(shapes) != 1 || shapes[0].ShapeHash != hash || shapes[0].InkCost != 6 || shapes[0].BlockHash != blockHash {
+		t.Error("Expected the shape on the canvas, got: ", shapes)
+	}
+	if ink, err = c.DeleteShape(0, hash); err != nil || ink != 100 {
+		t.Error("Expected the ink refunded, got: ", ink, err)
+	}
+	if shapes, _ = c.GetCanvas(""); len(shapes) != 0 {
+		t.Error("Expected an empty canvas, got: ", shapes)
+	}
+	if _, err = c.DeleteShape(0, hash); err != blockartlib.ShapeOwnerError(hash) {
+		t.Error("Expected ShapeOwnerError, got: ", err)
+	}
+	if _, err = c.GetSvgString("nope"); err != blockartlib.InvalidShapeHashError("nope") {
+		t.Error("Expected InvalidShapeHashError, got: ", err)
+	}
+}
+
+func TestValidation(t *testing.T) {
+	c := NewCanvas(testSettings, 10)
+	other, _ := c.AddShapeAs("someone", blockartlib.PATH, "M 0 0 L 5 0", "transparent", "blue", 1)
+	tests := []struct {
+		svg      string
+		fill     string
+		expected error
+	}{
+		{"M 0 0 L 0 5", "transparent", blockartlib.ShapeOverlapError(other)},
+		{"M 0 10 L 0 50", "transparent", blockartlib.InsufficientInkError(10)},
+		{"M 0 10 L 0 500", "transparent", blockartlib.OutOfBoundsError{X: 0, Y: 500}},
+		{"M 0 10 L 0 5", "\"/><script>", blockartlib.InvalidColourError("\"/><script>")},
+	}
+	for _, test := range tests {
+		if _, _, _, err := c.AddShape(0, blockartlib.PATH, test.svg, test.fill, "red"); err != test.expected {
+			t.Error("Expected ", test.expected, " for ", test.svg, " got: ", err)
+		}
+	}
+	if ink, _ := c.GetInk(); ink != 10 {
+		t.Error("Expected no ink spent on rejected shapes, got: ", ink)
+	}
+}
+
+// The fake makes the checks of blockartlib and draws shapes as the miner
+// does, on every path a shape is added by.
+func TestSharedShapeArgs(t *testing.T) {
+	c := NewCanvas(testSettings, 1000)
+	points := make([]blockartlib.Point, 65)
+	for i := range points {
+		points[i] = blockartlib.Point{X: i, Y: i % 2}
+	}
+	tooLong := blockartlib.ShapeSvgStringTooLongError("more than 64 points")
+	if _, _, _, err := c.AddShapeWithParams(0, blockartlib.POLYLINE, blockartlib.ShapeParams{Points: points}, "transparent", "red", 1); err != tooLong {
+		t.Error("Expected ", tooLong, " from AddShapeWithParams, got: ", err)
+	}
+	batch := []blockartlib.BatchShape{{SType: blockartlib.POLYLINE, Params: blockartlib.ShapeParams{Points: points},
+		Fill: "transparent", Stroke: "red", StrokeWidth: 1}}
+	if _, _, _, err := c.AddShapes(0, batch); err != tooLong {
+		t.Error("Expected ", tooLong, " from AddShapes, got: ", err)
+	}
+	if _, _, _, err := c.AddShapeAsync(0, blockartlib.PATH, "M 0 0 L 0 5", blockartlib.ShapeParams{}, "transparent", "reddish", 1).Wait(); err != blockartlib.InvalidColourError("reddish") {
+		t.Error("Expected InvalidColourError from AddShapeAsync, got: ", err)
+	}
+	if _, err := c.EstimateShape(blockartlib.POLYLINE, "", blockartlib.ShapeParams{Points: points}, "transparent", "red", 1); err != tooLong {
+		t.Error("Expected ", tooLong, " from EstimateShape, got: ", err)
+	}
+	if _, err := c.EstimateShape(blockartlib.PATH, "M 0 0 L 0 5", blockartlib.ShapeParams{}, "transparent", "transparent", 1); err == nil {
+		t.Error("Expected an error from EstimateShape for a shape with no colour")
+	}
+
+	params := blockartlib.ShapeParams{X: 10, Y: 10, Width: 5, Height: 5}
+	shapeHash, _, _, err := c.AddShapeWithParams(0, blockartlib.RECT, params, "transparent", "red", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, element, _ := blockartlib.ShapeElement(blockartlib.RECT, "", params, "transparent", "red", 2)
+	if svg, _ := c.GetSvgString(shapeHash); svg != element {
+		t.Error("Expected ", element, " got: ", svg)
+	}
+
+	params.X = 30
+	estimate, err := c.EstimateShape(blockartlib.RECT, "", params, "transparent", "red", 2)
+	if err != nil || !estimate.InBounds || estimate.InkCost == 0 {
+		t.Fatal("Expected an estimate for the rect, got: ", estimate, err)
+	}
+	before, _ := c.GetInk()
+	shapeHash, _, ink, err := c.AddShapeAsync(0, blockartlib.RECT, "", params, "transparent", "red", 2).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before-ink != estimate.InkCost {
+		t.Error("Expected the async rect to cost ", estimate.InkCost, " got: ", before-ink)
+	}
+	_, element, _ = blockartlib.ShapeElement(blockartlib.RECT, "", params, "transparent", "red", 2)
+	if svg, _ := c.GetSvgString(shapeHash); svg != element {
+		t.Error("Expected ", element, " from AddShapeAsync, got: ", svg)
+	}
+}
+
+func TestGetBlock(t *testing.T) {
+	c := NewCanvas(testSettings, 100)
+	genesis, _ := c.GetGenesisBlock()
+	shapeHash, blockHash, _, err := c.AddShape(1, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	children, _ := c.GetChildren(blockHash)
+	if len(children) != 1 {
+		t.Fatal("Expected one block confirming the shape, got: ", children)
+	}
+	tests := []struct {
+		blockHash  string
+		noOp       bool
+		inkAwarded uint32
+		ops        int
+	}{
+		{genesis, true, 0, 0},
+		{blockHash, false, InkPerOpBlock, 1},
+		{children[0], true, InkPerNoOpBlock, 0},
+	}
+	for _, test := range tests {
+		block, err := c.GetBlock(test.blockHash)
+		if err != nil || block.NoOpBlock != test.noOp || block.InkAwarded != test.inkAwarded || len(block.Ops) != test.ops {
+			t.Error("Expected no-op ", test.noOp, " with ", test.inkAwarded, " ink and ", test.ops, " ops, got: ", block, err)
+		}
+	}
+	if block, _ := c.GetBlock(blockHash); block.Ops[0] != (blockartlib.BlockOp{Kind: "add", ShapeHash: shapeHash, Owner: Owner, InkCost: 6}) {
+		t.Error("Expected the add op of the shape, got: ", block.Ops)
+	}
+}
+
+func TestInjectedError(t *testing.T) {
+	c := NewCanvas(testSettings, 100)
+	c.SetError("AddShape", blockartlib.DisconnectedError("miner"))
+	if _, _, _, err := c.AddShapeContext(context.Background(), 0, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red"); err != blockartlib.DisconnectedError("miner") {
+		t.Error("Expected DisconnectedError, got: ", err)
+	}
+	c.SetError("AddShape", nil)
+	if _, _, _, err := c.AddShape(0, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red"); err != nil {
+		t.Error("Expected no error, got: ", err)
+	}
+}
+
+func TestSimulatedConfirmations(t *testing.T) {
+	c := NewCanvas(testSettings, 100)
+	c.SetBlockInterval(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, _, _, err := c.AddShapeContext(ctx, 3, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Error("Expected DeadlineExceeded, got: ", err)
+	}
+
+	h := c.AddShapeAsync(2, blockartlib.PATH, "M 10 0 L 10 5", blockartlib.ShapeParams{}, "transparent", "red", 1)
+	var states []blockartlib.ShapeState
+	for status := range h.Status {
+		states = append(states, status.State)
+	}
+	if _, _, _, err := h.Wait(); err != nil || len(states) != 4 || states[3] != blockartlib.ShapeConfirmed {
+		t.Error("Expected pending, included and two confirmations, got: ", states, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	c := NewCanvas(testSettings, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	events, _ := c.Watch(ctx)
+	hash, _, _, _ := c.AddShape(0, blockartlib.PATH, "M 0 0 L 0 5", "transparent", "red")
+	c.MoveShape(0, hash, 5, 0)
+	for _, kind := range []string{"add", "move"} {
+		select {
+		case e := <-events:
+			if e.Kind != kind || e.ShapeHash != hash || e.Owner != Owner {
+				t.Error("Expected ", kind, " of ", hash, " got: ", e)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected a ", kind, " event")
+		}
+	}
+	if svg, _ := c.GetSvgString(hash); svg != "<path d=\"M 5 0 L 5 5\" stroke=\"red\" stroke-width=\"1\" fill=\"transparent\"/>" {
+		t.Error("Expected the moved path, got: ", svg)
+	}
+}
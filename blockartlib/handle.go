@@ -0,0 +1,132 @@
+package blockartlib
+
+import (
+	"context"
+	"time"
+)
+
+// Progress of a shape submitted with AddShapeAsync.
+type ShapeState int
+
+const (
+	// The miner accepted the shape and is putting it in a block.
+	ShapePending ShapeState = iota
+
+	// The shape is in block BlockHash.
+	ShapeIncluded
+
+	// Depth blocks follow the block of the shape.
+	ShapeConfirmed
+
+	// The block of the shape left the longest chain.
+	ShapeOrphaned
+
+	// The shape was not added, Err says why.
+	ShapeRejected
+)
+
+// A status update of a submitted shape.
+type ShapeStatus struct {
+	State     ShapeState
+	BlockHash string // block of the shape, unless pending or rejected
+	Depth     int    // number of blocks after BlockHash
+	Err       error  // reason the shape was rejected or orphaned
+}
+
+// Handle of a shape submitted with AddShapeAsync.
+// Status receives every update in order and is closed after the last one:
+// ShapeConfirmed at depth validateNum, ShapeOrphaned or ShapeRejected.
+// Updates are buffered, so the handle works without reading Status.
+type ShapeHandle struct {
+	Status <-chan ShapeStatus
+
+	done         chan struct{}
+	shapeHash    string
+	blockHash    string
+	inkRemaining uint32
+	err          error
+}
+
+// how often the miner is asked how deep the shape is
+var shapeStatusPollInterval = 3 * time.Second
+
+// Blocks until the shape is confirmed by validateNum blocks, orphaned or
+// rejected. Returns the same results as AddShape, or ShapeOrphanedError.
+func (h *ShapeHandle) Wait() (shapeHash string, blockHash string, inkRemaining uint32, err error) {
+	<-h.done
+	return h.shapeHash, h.blockHash, h.inkRemaining, h.err
+}
+
+// Returns a handle for a Canvas implementation other than the one
+// OpenCanvas returns, such as a test fake. The implementation sends the
+// updates on status, which buffers buffer of them, and then calls done
+// with the results Wait returns. done closes Status.
+func NewShapeHandle(buffer int) (h *ShapeHandle, status chan<- ShapeStatus, done func(shapeHash string, blockHash string, inkRemaining uint32, err error)) {
+	ch := make(chan ShapeStatus, buffer)
+	h = &ShapeHandle{Status: ch, done: make(chan struct{})}
+	done = func(shapeHash string, blockHash string, inkRemaining uint32, err error) {
+		h.shapeHash, h.blockHash, h.inkRemaining, h.err = shapeHash, blockHash, inkRemaining, err
+		close(ch)
+		close(h.done)
+	}
+	return h, ch, done
+}
+
+func (c *MyCanvas) AddShapeAsync(validateNum uint8, shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) *ShapeHandle {
+	// pending, included, every depth and the final update
+	status := make(chan ShapeStatus, int(validateNum)+3)
+	h := &ShapeHandle{Status: status, done: make(chan struct{})}
+	go c.followShape(h, status, validateNum, shapeType, shapeSvgString, params, fill, stroke, strokeWidth)
+	return h
+}
+
+// Submits the shape without waiting for confirmations, then polls the
+// miner until the shape is deep enough or its block is gone.
+func (c *MyCanvas) followShape(h *ShapeHandle, status chan ShapeStatus, validateNum uint8, shapeType ShapeType, shapeSvgString string, params ShapeParams, fill string, stroke string, strokeWidth uint32) {
+	defer close(h.done)
+	defer close(status)
+	reject := func(err error) {
+		h.err = err
+		status <- ShapeStatus{State: ShapeRejected, Err: err}
+	}
+	if err := CheckShapeArgs(shapeType, shapeSvgString, params, fill, stroke, strokeWidth, c.canvasSettings()); err != nil {
+		reject(err)
+		return
+	}
+	status <- ShapeStatus{State: ShapePending}
+	args := AddShapeStruct{0, shapeType, shapeSvgString, fill, stroke, c.artnodePrivKey, strokeWidth, params, ""}
+	reply := AddShapeReply{}
+	if err := c.call(context.Background(), "InkMinerRPC.AddShape", "", args, &reply); err != nil {
+		reject(err)
+		return
+	}
+	h.shapeHash, h.blockHash, h.inkRemaining = reply.ShapeHash, reply.BlockHash, reply.InkRemaining
+	status <- ShapeStatus{State: ShapeIncluded, BlockHash: h.blockHash}
+
+	depth := 0
+	for depth < int(validateNum) {
+		time.Sleep(shapeStatusPollInterval)
+		var s ShapeStatusReply
+		err := c.call(context.Background(), "InkMinerRPC.GetShapeStatus", "", h.shapeHash, &s)
+		if _, lost := err.(DisconnectedError); lost {
+			reject(err)
+			return
+		}
+		if err != nil || s.BlockHash != h.blockHash {
+			h.err = ShapeOrphanedError(h.blockHash)
+			status <- ShapeStatus{State: ShapeOrphaned, BlockHash: h.blockHash, Err: h.err}
+			return
+		}
+		if s.Depth > depth {
+			depth = minInt(s.Depth, int(validateNum))
+			status <- ShapeStatus{State: ShapeConfirmed, BlockHash: h.blockHash, Depth: depth}
+		}
+	}
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
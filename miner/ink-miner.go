@@ -2,7 +2,12 @@ package main
 
 /*
 	Usage:
-	go run ink-miner.go [server ip:port] [priv-key] [miner listen port] [art-app listen port]
+	go run ink-miner.go [server ip:port] [priv-key] [miner listen port] [art-app listen port] [http port] [view port]
+
+	The http port is optional, it serves the art node operations as JSON,
+	see the HTTP/JSON gateway below. "-" leaves the gateway off.
+	The view port is optional too, it serves a live web view of the canvas
+	on 127.0.0.1, see Live web view of the canvas below.
 */
 
 // package ink-miner
@@ -11,19 +16,27 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"encoding/gob"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"math/big"
 	"net"
+	"net/http"
 	"net/rpc"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"../SvgHelper"
@@ -41,9 +54,34 @@ var (
 	localIPPortStr    string
 	localIPPortArr    [2]string
 	artAppListenPort  string
-	globalPubKeyStr   string = ""
+	globalPubKeyStr   string                = ""
+	canvasIndex       *SvgHelper.ShapeIndex // spatial index over CanvasShapes of the last block
+	waitingRequests   requestSet            = requestSet{ids: make(map[string]chan struct{})}
+	canvasEvents      eventLog              = eventLog{changed: make(chan struct{})}
 )
 
+// Events for the shape ops of the longest chain, in the order the miner
+// saw them. announced holds the block hashes of the chain the events so far
+// describe, and blockEvents the events of each of those blocks.
+type eventLog struct {
+	sync.Mutex
+	events      []CanvasEvent
+	first       int      // Seq of events[0], older events are dropped
+	announced   []string // block hashes, from the first block to the tip
+	blockEvents [][]CanvasEvent
+	changed     chan struct{} // closed and replaced when events are added
+}
+
+// most events kept for watchers that fall behind
+const maxCanvasEvents = 10000
+
+// Requests of art nodes that wait for confirmations. The channel of a
+// request is closed when the art node cancels it.
+type requestSet struct {
+	sync.Mutex
+	ids map[string]chan struct{}
+}
+
 type allMinersConnectedTo struct {
 	sync.RWMutex
 	currentNumNeighbours int
@@ -55,6 +93,14 @@ type MinerInfo struct {
 	Key     ecdsa.PublicKey
 }
 
+// Signature of the sha256 of the nonce from RServer.DeregisterNonce, which
+// proves to the server that the miner deregistering holds the key.
+type DeregisterArgs struct {
+	Key ecdsa.PublicKey
+	R   *big.Int
+	S   *big.Int
+}
+
 // Settings for a canvas in BlockArt.
 type CanvasSettings struct {
 	// Canvas dimensions
@@ -91,11 +137,13 @@ type MinerNetSettings struct {
 }
 
 type Operation struct {
-	AppShape      string
-	OpSig         string
-	PubKeyArtNode string //key of the art node that generated the op
-	ShapeCommand  string // e.g. "M 0 0 L 0 3"
-	ShapeFill     string // fill or transparent
+	AppShape         string
+	OpSig            string
+	PubKeyArtNode    string //key of the art node that generated the op
+	ShapeCommand     string // e.g. "M 0 0 L 0 3"
+	ShapeFill        string // fill or transparent
+	ShapeStroke      string // stroke colour
+	ShapeStrokeWidth int    // stroke width in pixels
 }
 
 type Coordinate struct {
@@ -122,8 +170,8 @@ type Block struct {
 	PubKeyMiner      string
 	Index            int
 	MinerInks        map[string]InkAccount
-	CanvasInks       map[string]SvgHelper.MapPoint
-	CanvasOperations map[string][]string // Ink Miner to List of Operations on canvas
+	CanvasShapes     map[string]SvgHelper.Shape // Shape hash to geometry of shapes on canvas
+	CanvasOperations map[string][]string        // Ink Miner to List of Operations on canvas
 }
 
 /********************************
@@ -134,8 +182,16 @@ type MinerRPCs interface {
 	Connect(privatekey string, reply *ValidMiner) error
 	GetInk(privatekey string, reply *uint32) error
 	AddShape(args AddShapeStruct, reply *AddShapeReply) error
+	AddShapes(args AddShapesArgs, reply *AddShapesReply) error
+	EstimateShape(args AddShapeStruct, reply *SvgHelper.ShapeEstimate) error
 	GetSvgString(shapeHash string, svgString *string) error
 	DeleteShape(args DelShapeArgs, inkRemaining *uint32) error
+	MoveShape(args MoveShapeArgs, reply *MoveShapeReply) error
+	CancelRequest(requestID string, reply *bool) error
+	GetShapeStatus(shapeHash string, reply *ShapeStatusReply) error
+	WatchCanvas(args WatchArgs, reply *WatchReply) error
+	GetCanvas(blockHash string, reply *[]CanvasShape) error
+	GetBlock(blockHash string, reply *BlockInfo) error
 	GetShapes(blockHash string, shapeHashes *[]string) error
 	GetGenesisBlock(args int, blockHash *string) error
 	GetChildren(blockHash string, blockHashes *[]string) error
@@ -152,11 +208,7 @@ Structs for RPC calls for miner2miner
 type Miner2MinerRPCs interface {
 	PrintText(textToPrint string, reply *string) error
 	EstablishReverseRPC(addr string, reply *string) error
-<<<<<<< HEAD
 	SendBlockChain(bc []Block, reply *string) error
-=======
-	SendBlockchain(bc []Block, reply *string) error
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 }
 
 // Interface between art app and ink miner
@@ -175,6 +227,14 @@ type ShapeType int
 const (
 	// Path shape.
 	PATH ShapeType = iota
+	// Rectangle given by AddShapeStruct.Params.
+	RECT
+	// Ellipse given by AddShapeStruct.Params.
+	ELLIPSE
+	// Closed shape through AddShapeStruct.Params.Points.
+	POLYGON
+	// Open line through AddShapeStruct.Params.Points.
+	POLYLINE
 	// Circle shape (extra credit).
 	// CIRCLE
 )
@@ -186,6 +246,9 @@ type AddShapeStruct struct {
 	Fill           string
 	Stroke         string
 	ArtNodePK      string
+	StrokeWidth    uint32
+	Params         SvgHelper.ShapeParams // shape of RECT, ELLIPSE, POLYGON and POLYLINE
+	RequestID      string                // lets the art node cancel waiting for confirmations
 }
 
 type AddShapeReply struct {
@@ -194,17 +257,105 @@ type AddShapeReply struct {
 	InkRemaining uint32
 }
 
+// Shapes added together in one block. ArtNodePK and RequestID of the
+// shapes are ignored, those of the batch are used.
+type AddShapesArgs struct {
+	ValidateNum uint8
+	Shapes      []AddShapeStruct
+	ArtNodePK   string
+	RequestID   string
+}
+
+type AddShapesReply struct {
+	ShapeHashes  []string // in the order of the shapes of the batch
+	BlockHash    string
+	InkRemaining uint32
+}
+
 var myKeyPairInString string
 
 type DelShapeArgs struct {
 	ValidateNum uint8
 	ShapeHash   string
 	ArtNodePK   string
+	RequestID   string
+}
+
+type MoveShapeArgs struct {
+	ValidateNum uint8
+	ShapeHash   string
+	ArtNodePK   string
+	Dx          int
+	Dy          int
+	RequestID   string
+}
+
+type MoveShapeReply struct {
+	BlockHash    string
+	InkRemaining uint32
+}
+
+type ShapeStatusReply struct {
+	BlockHash string // block on the longest chain that added the shape
+	Depth     int    // number of blocks after that block
+}
+
+// A change of a shape on the longest chain. When a block leaves the
+// longest chain its events are sent again, newest first, with Retracted set.
+type CanvasEvent struct {
+	Seq       int    // position in the event log of the miner
+	Kind      string // "add", "delete" or "move"
+	ShapeHash string
+	SvgString string // svg element of the shape, for add and move
+	Owner     string // key of the art node that owns the shape
+	BlockHash string // block with the op
+	Retracted bool   // the block left the longest chain, undo the event
+}
+
+// A shape on the canvas at some block.
+type CanvasShape struct {
+	ShapeHash   string
+	SvgString   string // svg element the shape is drawn with
+	Path        string // svg path with the geometry of the shape
+	Owner       string // key of the art node that owns the shape
+	Fill        string
+	Stroke      string
+	StrokeWidth int
+	InkCost     uint32
+	BlockHash   string // block that added the shape
+}
+
+// Header and ops of a block, for art nodes to inspect the chain.
+type BlockInfo struct {
+	BlockHash  string
+	PrevHash   string
+	Nonce      uint32
+	Height     int    // number of blocks before it, 0 for the genesis block
+	MinerKey   string // key of the miner that mined the block
+	NoOpBlock  bool
+	Ops        []BlockOp // ops the block added, in order
+	InkAwarded uint32    // ink the miner got for the block
+}
+
+// An op of a block.
+type BlockOp struct {
+	Kind      string // "add", "delete" or "move"
+	ShapeHash string
+	Owner     string // key of the art node that made the op
+	InkCost   uint32 // ink of the shape for an add, 0 for delete and move
+}
+
+type WatchArgs struct {
+	After int // Seq of the last event seen, -1 for all events
+}
+
+type WatchReply struct {
+	Events []CanvasEvent
 }
 
 type CloseCanvReply struct {
-	canvOps      map[string][]string
-	inkRemaining uint32
+	Shapes       []CanvasShape // shapes at the tip of the longest chain
+	InkRemaining uint32
 }
 
 // Provided by server.go code as part of repository
@@ -248,6 +399,58 @@ func (e InsufficientInkError) Error() string {
 	return fmt.Sprintf("BlockArt: Not enough ink to addShape [%d]", uint32(e))
 }
 
+// Error returned to art nodes. net/rpc only sends the text of an error, so
+// the text is this envelope as JSON: Code names the typed error and Payload
+// holds its value, which lets blockartlib rebuild the typed error.
+type ErrorEnvelope struct {
+	Code    string
+	Payload string
+	Message string
+}
+
+func (e ErrorEnvelope) Error() string {
+	text, _ := json.Marshal(e)
+	return string(text)
+}
+
+// Replaces the error returned by an RPC method with its envelope. Errors
+// without a code keep their message only.
+func envelopeError(err *error) {
+	if *err == nil {
+		return
+	}
+	e := ErrorEnvelope{Message: (*err).Error()}
+	switch t := (*err).(type) {
+	case ErrorEnvelope:
+		return
+	case InsufficientInkError:
+		e.Code, e.Payload = "InsufficientInk", strconv.FormatUint(uint64(t), 10)
+	case SvgHelper.InsufficientInkError:
+		e.Code, e.Payload = "InsufficientInk", strconv.FormatUint(uint64(t), 10)
+	case SvgHelper.OutOfBoundsError:
+		e.Code, e.Payload = "OutOfBounds", strconv.Itoa(t.X)+","+strconv.Itoa(t.Y)
+	case SvgHelper.ShapeOverlapError:
+		e.Code, e.Payload = "ShapeOverlap", string(t)
+	case ShapeOwnerError:
+		e.Code, e.Payload = "ShapeOwner", string(t)
+	case SvgHelper.ShapeOwnerError:
+		e.Code, e.Payload = "ShapeOwner", string(t)
+	case SvgHelper.InvalidShapeSvgStringError:
+		e.Code, e.Payload = "InvalidShapeSvgString", string(t)
+	case SvgHelper.ShapeSvgStringTooLongError:
+		e.Code, e.Payload = "ShapeSvgStringTooLong", string(t)
+	case SvgHelper.InvalidColourError:
+		e.Code, e.Payload = "InvalidColour", string(t)
+	case InvalidShapeHashError:
+		e.Code, e.Payload = "InvalidShapeHash", string(t)
+	case InvalidBlockHashError:
+		e.Code, e.Payload = "InvalidBlockHash", string(t)
+	case InvalidMinerPKError:
+		e.Code, e.Payload = "InvalidMinerPK", string(t)
+	}
+	*err = e
+}
+
 func main() {
 	// Read in command line args
 	// args[0] is server:port, args[1] is private key, args[2] is miner port, args[3] is art-app port
@@ -283,18 +486,26 @@ func main() {
 	cRPC, err := rpc.Dial("tcp", ipPort)
 	defer cRPC.Close()
 	if err != nil {
-		fmt.Println("Error dialing to server ", err.Error)
+		fmt.Println("Error dialing to server ", err.Error())
 	}
 	fmt.Println("Miner address is ====== " + addr.String())
 	myMinerInfo = MinerInfo{Address: addr, Key: myPrivKey.PublicKey}
 	err = cRPC.Call("RServer.Register", myMinerInfo, &settings)
 	exitOnError(fmt.Sprintf("client registration for %s", myMinerInfo.Address), err)
 	listenToArtnode(ipPort)
+	if len(args) > 4 && args[4] != "-" {
+		listenToHTTP(args[4])
+	}
+	if len(args) > 5 {
+		listenToView(args[5])
+	}
 
 	go sendHeartBeats(ipPort, myMinerInfo, settings.HeartBeat)
+	go deregisterOnInterrupt(ipPort, myMinerInfo)
 	go listenForIncomingConnections(portInt)
 
 	go monitorNumConnections(ipPort)
+	go publishCanvasEvents()
 
 	for {
 		sleep_time := 3000 * time.Millisecond
@@ -313,14 +524,8 @@ func main() {
 		fmt.Printf("lastOne index: %d\n", lastOne)
 		fmt.Printf("Last blk index: %d\n", blockChain[lastOne].Index)
 		//fmt.Printf("globalPubKeyStr: %s\n", globalPubKeyStr)
-<<<<<<< HEAD
 		inkMinedRightNow := blockChain[lastOne].MinerInks[globalPubKeyStr].InkMined
 		inkRemainingRightNow := blockChain[lastOne].MinerInks[globalPubKeyStr].InkRemain
-=======
-		inkMinedRightNow := blockChain[lastOne].MinerInks[globalPubKeyStr].inkMined
-		inkRemainingRightNow := blockChain[lastOne].MinerInks[globalPubKeyStr].inkRemain
-
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 		fmt.Printf("My ink mined is %d remaining is: %d\n", inkMinedRightNow, inkRemainingRightNow)
 	}
 }
@@ -347,7 +552,7 @@ func generateNoOpBlock(minerPubKey string) Block {
 	}
 
 	opsArr := make([]Operation, 0)
-	cInks := lastBlk.CanvasInks
+	cShapes := lastBlk.CanvasShapes
 	cOps := lastBlk.CanvasOperations
 
 	lastBlkHash, _ := calculateHash(lastBlk, difficulty)
@@ -360,7 +565,7 @@ func generateNoOpBlock(minerPubKey string) Block {
 		PubKeyMiner:      globalPubKeyStr,
 		Index:            lastBlockIndex + 1,
 		MinerInks:        lastBlk.MinerInks,
-		CanvasInks:       cInks,
+		CanvasShapes:     cShapes,
 		CanvasOperations: cOps,
 	}
 
@@ -372,13 +577,8 @@ func generateNoOpBlock(minerPubKey string) Block {
 		myInkAccount.InkMined = myInkAccount.InkMined + settings.InkPerNoOpBlock
 		myInkAccount.InkRemain = myInkAccount.InkRemain + settings.InkPerNoOpBlock
 		oldMinerInks[minerPubKey] = myInkAccount
-<<<<<<< HEAD
-		//str := minerPubKey
-		//fmt.Printf("in gen noop block: %s\n", str)
-=======
 		// str := minerPubKey
 		// fmt.Printf("in gen noop block: %s\n", str)
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 		blk.MinerInks = oldMinerInks
 	} else {
 		fmt.Println("setting ink for first time")
@@ -409,7 +609,7 @@ func generateFirstBlock() (Block, error) {
 	opsArr := make([]Operation, 0)
 	mInks := make(map[string]InkAccount)
 	mInks[globalPubKeyStr] = InkAccount{InkMined: settings.InkPerNoOpBlock, InkRemain: settings.InkPerNoOpBlock, InkSpent: 0}
-	cInks := make(map[string]SvgHelper.MapPoint)
+	cShapes := make(map[string]SvgHelper.Shape)
 	cOps := make(map[string][]string)
 
 	blk := Block{
@@ -420,7 +620,7 @@ func generateFirstBlock() (Block, error) {
 		PubKeyMiner:      globalPubKeyStr,
 		Index:            1,
 		MinerInks:        mInks,
-		CanvasInks:       cInks,
+		CanvasShapes:     cShapes,
 		CanvasOperations: cOps,
 	}
 
@@ -432,7 +632,7 @@ func generateFirstBlock() (Block, error) {
 }
 
 func blkToString(b Block) string {
-	return b.PrevHash + convertOpToString(b.Ops) + b.PubKeyMiner + string(b.Index)
+	return b.PrevHash + convertOpToString(b.Ops) + b.PubKeyMiner + strconv.Itoa(b.Index)
 }
 
 // [prev-hash, op, op-signature, pub-key, nonce, other data structures]
@@ -513,28 +713,72 @@ Miner-Server Communication
 ****************************/
 
 /*
-Send heartbeats to server at regular intervals to maintain RPC connection
+Send heartbeats to server at regular intervals to maintain RPC connection.
+If the server goes away the miner dials it again, and if the server does not
+know the miner any more, after a restart or a late heartbeat, the miner
+registers again.
 */
 func sendHeartBeats(ipPort string, miner MinerInfo, heartBeatInterval uint32) {
-
-	cRPC, err := rpc.Dial("tcp", ipPort)
-	defer cRPC.Close()
-	if err != nil {
-		fmt.Println(err.Error)
-	}
-
+	var cRPC *rpc.Client
 	hbInMilliSec := time.Duration(heartBeatInterval) * time.Millisecond
 	timeToSleep := hbInMilliSec / 20
 	fmt.Println(timeToSleep)
 	for {
 		time.Sleep(timeToSleep)
-		err = cRPC.Call("RServer.HeartBeat", miner.Key, &_ignored)
+		if cRPC == nil {
+			c, err := rpc.Dial("tcp", ipPort)
+			if err != nil {
+				fmt.Println("server unreachable:", err)
+				continue
+			}
+			cRPC = c
+		}
+		err := cRPC.Call("RServer.HeartBeat", miner.Key, &_ignored)
+		if _, fromServer := err.(rpc.ServerError); err != nil && !fromServer {
+			cRPC.Close()
+			cRPC = nil
+			continue
+		}
 		if err != nil {
-			exitOnError("late heartbeat", ExpectedError)
+			fmt.Println("late heartbeat, registering again:", err)
+			var s MinerNetSettings
+			if err = cRPC.Call("RServer.Register", miner, &s); err != nil {
+				fmt.Println("register again:", err)
+			}
 		}
 	}
 }
 
+// Deregisters the miner from the server when the miner is interrupted, so
+// the server stops handing out its address.
+func deregisterOnInterrupt(ipPort string, miner MinerInfo) {
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	<-interrupt
+	if cRPC, err := rpc.Dial("tcp", ipPort); err == nil {
+		if err = deregister(cRPC, miner.Key, myPrivKey); err != nil {
+			fmt.Println("deregister:", err)
+		}
+		cRPC.Close()
+	}
+	os.Exit(0)
+}
+
+// Signs a nonce from the server with the private key of the miner to
+// deregister it.
+func deregister(cRPC *rpc.Client, key ecdsa.PublicKey, privKey *ecdsa.PrivateKey) error {
+	var nonce []byte
+	if err := cRPC.Call("RServer.DeregisterNonce", key, &nonce); err != nil {
+		return err
+	}
+	hash := sha256.Sum256(nonce)
+	r, s, err := ecdsa.Sign(rand.Reader, privKey, hash[:])
+	if err != nil {
+		return err
+	}
+	return cRPC.Call("RServer.Deregister", DeregisterArgs{key, r, s}, &_ignored)
+}
+
 /*
 A wrapper on the GetNodes RPC call. It invokes a GetNodes RPC call only if the
 current number of connections is less than the minimum.
@@ -549,7 +793,7 @@ func helperGetNodes(ipPort string, miner MinerInfo, addrSet *[]net.Addr) bool {
 		cRPC, err := rpc.Dial("tcp", ipPort)
 		defer cRPC.Close()
 		if err != nil {
-			fmt.Println(err.Error)
+			fmt.Println(err.Error())
 		}
 
 		err = cRPC.Call("RServer.GetNodes", miner.Key, addrSet)
@@ -579,7 +823,7 @@ func connectToMiner(addr net.Addr) {
 	fmt.Println(addr.String())
 	miner2minerRPC, err := rpc.Dial("tcp", addr.String())
 	if err != nil {
-		fmt.Println(err.Error)
+		fmt.Println(err.Error())
 	}
 	minersConnectedTo.Lock()
 	defer minersConnectedTo.Unlock()
@@ -598,13 +842,13 @@ func connectToMiner(addr net.Addr) {
 		fmt.Println("Issue with EstablishReverseRPC", err)
 	}
 	fmt.Printf("Did other side connect to me?: %s\n", reply)
-	go handleMiner(*miner2minerRPC, addr)
+	go handleMiner(miner2minerRPC, addr)
 }
 
 /*
 A handler that handles all logic between two miners
 */
-func handleMiner(otherMiner rpc.Client, otherMinerAddr net.Addr) {
+func handleMiner(otherMiner *rpc.Client, otherMinerAddr net.Addr) {
 	defer otherMiner.Close()
 	minersConnectedTo.Lock()
 	minersConnectedTo.currentNumNeighbours = minersConnectedTo.currentNumNeighbours + 1
@@ -625,12 +869,7 @@ func handleMiner(otherMiner rpc.Client, otherMinerAddr net.Addr) {
 
 		var reply string
 		fmt.Println("Sending block chain to neighbour")
-<<<<<<< HEAD
 		err := otherMiner.Call("MinerToMinerRPC.SendBlockChain", blockChain, &reply)
-=======
-
-		err := otherMiner.Call("SendBlockChain", &blockChain, &reply)
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 		if err != nil {
 			fmt.Println("SendblockChain RPC call err, ", err)
 		}
@@ -655,7 +894,8 @@ func listenToArtnode(ipPort string) {
 	runtime.Gosched()
 }
 
-func (m *MinerRPC) Connect(minerprivatekey string, reply *ValidMiner) error {
+func (m *MinerRPC) Connect(minerprivatekey string, reply *ValidMiner) (err error) {
+	defer envelopeError(&err)
 	var v ValidMiner
 	// fmt.Println(getPrivKeyInStr(myPrivKey))
 	// fmt.Println(minerprivatekey)
@@ -671,17 +911,88 @@ func (m *MinerRPC) Connect(minerprivatekey string, reply *ValidMiner) error {
 	return InvalidMinerPKError(minerprivatekey)
 }
 
-func (m *MinerRPC) GetInk(minerprivatekey string, reply *uint32) error {
+func (m *MinerRPC) GetInk(minerprivatekey string, reply *uint32) (err error) {
+	defer envelopeError(&err)
 
 	if myKeyPairInString == minerprivatekey {
 		remainInk := minerInkRemain()
-		fmt.Println("@@@GetInk")
 		*reply = remainInk
 		return nil
 	}
 	return InvalidMinerPKError(minerprivatekey)
 }
 
+// Waits until validateNum blocks follow the block after lastOne, checking
+// every 3 seconds. Returns an error as soon as the art node cancels the
+// request; the op stays in its block either way.
+func waitForConfirmations(requestID string, lastOne int, validateNum uint8) error {
+	waitingRequests.Lock()
+	cancelled := waitingRequests.ids[requestID]
+	waitingRequests.Unlock()
+	for {
+		last := len(blockChain) - 1
+		if last > lastOne+int(validateNum) {
+			return nil
+		}
+		select {
+		case <-cancelled:
+			return errors.New("Request cancelled by art node: " + requestID)
+		case <-time.After(3 * time.Second):
+		}
+	}
+}
+
+// Lets the art node cancel the request from now on, so a cancel that arrives
+// before the request waits for confirmations still stops it. Returns the
+// function to call when the request is done.
+func startRequest(requestID string) func() {
+	if requestID == "" {
+		return func() {}
+	}
+	waitingRequests.Lock()
+	waitingRequests.ids[requestID] = make(chan struct{})
+	waitingRequests.Unlock()
+	return func() {
+		waitingRequests.Lock()
+		delete(waitingRequests.ids, requestID)
+		waitingRequests.Unlock()
+	}
+}
+
+// Stops an AddShape, AddShapes, DeleteShape or MoveShape call of the art node
+// from waiting for more confirmations. Replies false for a request that is
+// unknown or already done.
+func (m *MinerRPC) CancelRequest(requestID string, reply *bool) (err error) {
+	defer envelopeError(&err)
+	if requestID == "" {
+		return errors.New("Missing request id")
+	}
+	waitingRequests.Lock()
+	defer waitingRequests.Unlock()
+	ch, exist := waitingRequests.ids[requestID]
+	if !exist {
+		*reply = false
+		return nil
+	}
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+	*reply = true
+	return nil
+}
+
+// Returns the spatial index over the shapes on the canvas of the last block.
+// The index is rebuilt after the chain switched to another branch.
+func tipShapeIndex() *SvgHelper.ShapeIndex {
+	if canvasIndex == nil {
+		canvasIndex = SvgHelper.NewShapeIndex(blockChain[len(blockChain)-1].CanvasShapes,
+			SvgHelper.CanvasSettings(settings.CanvasSettings))
+	}
+	return canvasIndex
+}
+
 func minerInkRemain() uint32 {
 	if len(blockChain) == 0 {
 		return 0
@@ -692,28 +1003,39 @@ func minerInkRemain() uint32 {
 }
 
 // TODO:
-func (m *MinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) error {
+func (m *MinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) (err error) {
+	defer envelopeError(&err)
+	defer startRequest(args.RequestID)()
 	// try add this shape return shape/block hash, remained ink
-	svgStr := "<path d=\"" + args.ShapeSvgString + "\" stroke=\"" +
-		args.Stroke + "\" fill=\"" + args.Fill + "\"/>"
+	strokeWidth := int(args.StrokeWidth)
+	if strokeWidth == 0 {
+		strokeWidth = 1
+	}
+	path, svgStr, err := SvgHelper.ShapeElement(int(args.SType), args.ShapeSvgString, args.Params, args.Fill, args.Stroke, strokeWidth)
+	if err != nil {
+		return err
+	}
 
 	remainInk := int(minerInkRemain())
 	lastBlockIndex := len(blockChain) - 1
 	lastBlk := blockChain[lastBlockIndex]
-	previousMap := lastBlk.CanvasInks
-	fmt.Println("@@@ADDDD1", args.ShapeSvgString)
-	spentInk, err := SvgHelper.AddShapeToMap(args.ShapeSvgString, args.ArtNodePK, args.Fill,
-		remainInk, previousMap)
+	if lastBlk.CanvasShapes == nil {
+		lastBlk.CanvasShapes = make(map[string]SvgHelper.Shape)
+		blockChain[lastBlockIndex].CanvasShapes = lastBlk.CanvasShapes
+		canvasIndex = nil
+	}
+	previousMap := lastBlk.CanvasShapes
+	pkStr := getPubKeyInStr(myPrivKey.PublicKey)
+	shapeHash := computeNonceSecretHash(svgStr, pkStr) // use miner's public key
+	spentInk, err := SvgHelper.AddShapeToMap(shapeHash, path, args.ArtNodePK, args.Fill,
+		strokeWidth, remainInk, SvgHelper.CanvasSettings(settings.CanvasSettings), previousMap, tipShapeIndex())
 
 	currentInkRemain := remainInk - spentInk
-	fmt.Println("@@@ink remaining!!!! %d-----------spend!!!! %d-------currentinkRemain %d-------", remainInk, spentInk, currentInkRemain)
 	if err != nil {
 		return err
 	}
 
-	pkStr := getPubKeyInStr(myPrivKey.PublicKey)
-	shapeHash := computeNonceSecretHash(svgStr, pkStr) // use miner's public key
-	newOp := Operation{svgStr, shapeHash, args.ArtNodePK, args.ShapeSvgString, args.Fill}
+	newOp := Operation{svgStr, shapeHash, args.ArtNodePK, path, args.Fill, args.Stroke, strokeWidth}
 
 	lastOne := len(blockChain) - 1
 	var newBlock Block
@@ -721,7 +1043,7 @@ func (m *MinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) error {
 	if len(blockChain) == 0 {
 		newBlock, err1 = generateFirstBlock()
 		lastOne = 0
-		return InsufficientInkError(spentInk)
+		return InsufficientInkError(minerInkRemain())
 	}
 	newBlock, err1 = generateBlock(blockChain[lastOne])
 	preHash, _ := calculateHash(blockChain[lastOne], settings.PoWDifficultyOpBlock)
@@ -730,13 +1052,11 @@ func (m *MinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) error {
 	newOps = append(newOps, newOp)
 	mInks := blockChain[lastOne].MinerInks
 	incAcc := mInks[globalPubKeyStr]
-	fmt.Println("@@@ADD23DD")
 
 	_, inkMined := totalInkSpentAndMinedByMiner(blockChain, pkStr)
 	incAcc.InkMined = inkMined
 	incAcc.InkSpent = uint32(spentInk) + incAcc.InkSpent
 	incAcc.InkRemain = inkMined - incAcc.InkSpent
-	fmt.Println("@@@in incAcc inkMined!!!! %d-----------inkSpent!!!! %d-------incAcc.inkRemain %d-------", inkMined, incAcc.InkSpent, incAcc.InkRemain)
 
 	mInks[globalPubKeyStr] = incAcc
 
@@ -746,100 +1066,221 @@ func (m *MinerRPC) AddShape(args AddShapeStruct, reply *AddShapeReply) error {
 	myOps = append(myOps, svgAndHash)
 	canvOps[globalPubKeyStr] = myOps
 	newBlock = Block{preHash, 0, newOps, false, globalPubKeyStr, lastOne + 1, mInks,
-		previousMap, canvOps} // need update CanvasInks
+		previousMap, canvOps}
 	blockHash, nonce := calculateHash(newBlock, settings.PoWDifficultyOpBlock)
 	tmp, _ := strconv.ParseUint(nonce, 10, 32)
 	newBlock.Nonce = uint32(tmp)
 	blockChain = append(blockChain, newBlock)
-	fmt.Println("@@@ADD3DD")
 
-	for {
-		last := len(blockChain) - 1
-		if last > lastOne+int(args.ValidateNum) {
-			break
-		}
-		time.Sleep(3 * time.Second)
+	if err := waitForConfirmations(args.RequestID, lastOne, args.ValidateNum); err != nil {
+		return err
 	}
 	*reply = AddShapeReply{shapeHash, blockHash, uint32(currentInkRemain)}
 	return err1
 }
 
-func (m *MinerRPC) GetSvgString(shapeHash string, svgString *string) error {
+// Adds a batch of shapes in one block, all of them or none. The batch is
+// charged the ink of all its shapes and is rejected if any shape overlaps
+// another shape of the batch or a shape of another art node.
+func (m *MinerRPC) AddShapes(args AddShapesArgs, reply *AddShapesReply) (err error) {
+	defer envelopeError(&err)
+	defer startRequest(args.RequestID)()
+	if len(args.Shapes) == 0 {
+		return SvgHelper.InvalidShapeSvgStringError("empty batch")
+	}
 	lastOne := len(blockChain) - 1
-	operations := blockChain[lastOne].Ops
-	for i := 0; i < len(operations); i++ {
-		if operations[i].OpSig == shapeHash {
-			*svgString = operations[i].AppShape // svgString
-			return nil
+	if lastOne < 0 {
+		return InsufficientInkError(minerInkRemain())
+	}
+	pkStr := getPubKeyInStr(myPrivKey.PublicKey)
+	batch := make([]SvgHelper.Shape, len(args.Shapes))
+	newOps := blockChain[lastOne].Ops
+	var elements []string
+	for i, shapeArgs := range args.Shapes {
+		strokeWidth := int(shapeArgs.StrokeWidth)
+		if strokeWidth == 0 {
+			strokeWidth = 1
 		}
+		path, svgStr, err := SvgHelper.ShapeElement(int(shapeArgs.SType), shapeArgs.ShapeSvgString, shapeArgs.Params, shapeArgs.Fill, shapeArgs.Stroke, strokeWidth)
+		if err != nil {
+			return err
+		}
+		shapeHash := computeNonceSecretHash(svgStr, pkStr)
+		batch[i] = SvgHelper.Shape{ShapeHash: shapeHash, PublicKey: args.ArtNodePK, SvgString: path,
+			Fill: shapeArgs.Fill, StrokeWidth: strokeWidth}
+		newOps = append(newOps, Operation{svgStr, shapeHash, args.ArtNodePK, path, shapeArgs.Fill,
+			shapeArgs.Stroke, strokeWidth})
+		elements = append(elements, svgStr+":"+shapeHash)
+		reply.ShapeHashes = append(reply.ShapeHashes, shapeHash)
 	}
-	fmt.Println("@@@ GetSvgString fail")
-	return InvalidShapeHashError(shapeHash)
-}
 
-func (m *MinerRPC) DeleteShape(args DelShapeArgs, inkRemaining *uint32) error {
-	// try delete shape by args
-	lastOne := len(blockChain) - 1
-	if lastOne < 0 {
-		return InvalidShapeHashError(args.ShapeHash)
+	lastBlk := blockChain[lastOne]
+	if lastBlk.CanvasShapes == nil {
+		lastBlk.CanvasShapes = make(map[string]SvgHelper.Shape)
+		blockChain[lastOne].CanvasShapes = lastBlk.CanvasShapes
+		canvasIndex = nil
+	}
+	previousMap := lastBlk.CanvasShapes
+	remainInk := int(minerInkRemain())
+	spentInk, err := SvgHelper.AddShapesToMap(batch, remainInk, SvgHelper.CanvasSettings(settings.CanvasSettings),
+		previousMap, tipShapeIndex())
+	if err != nil {
+		return err
 	}
-<<<<<<< HEAD
-	fmt.Print(args, "##KKK(99999)6KK")
-	operations := blockChain[lastOne].Ops
-	for i := 0; i < len(operations); i++ {
-		fmt.Print(args.shapeHash, "##KKK6666666KK", operations[i].OpSig)
-		if operations[i].OpSig == args.shapeHash {
-			if args.ArtNodePK == operations[i].PubKeyArtNode {
-
-				fmt.Print("##KKKKKKK")
-				newOp := Operation{"delete", args.shapeHash, args.ArtNodePK, "", ""}
-				newBlock, _ := generateBlock(blockChain[lastOne])
-				var noOp uint8
-				if blockChain[lastOne].NoOpBlock {
-					noOp = settings.PoWDifficultyNoOpBlock
-				} else {
-					noOp = settings.PoWDifficultyOpBlock
-				}
-				preHash, _ := calculateHash(blockChain[lastOne], noOp)
 
-				newOps := blockChain[lastOne].Ops
-				newOps = append(newOps, newOp)
+	newBlock, err1 := generateBlock(lastBlk)
+	preHash, _ := calculateHash(lastBlk, settings.PoWDifficultyOpBlock)
+	mInks := lastBlk.MinerInks
+	incAcc := mInks[globalPubKeyStr]
+	_, inkMined := totalInkSpentAndMinedByMiner(blockChain, pkStr)
+	incAcc.InkMined = inkMined
+	incAcc.InkSpent = uint32(spentInk) + incAcc.InkSpent
+	incAcc.InkRemain = inkMined - incAcc.InkSpent
+	mInks[globalPubKeyStr] = incAcc
 
-				lastBlk := blockChain[lastOne]
-				mInks := lastBlk.MinerInks
-				incAcc := mInks[globalPubKeyStr]
-				previousMap := lastBlk.CanvasInks
+	canvOps := lastBlk.CanvasOperations
+	canvOps[globalPubKeyStr] = append(canvOps[globalPubKeyStr], elements...)
+	newBlock = Block{preHash, 0, newOps, false, globalPubKeyStr, lastOne + 1, mInks,
+		previousMap, canvOps}
+	blockHash, nonce := calculateHash(newBlock, settings.PoWDifficultyOpBlock)
+	tmp, _ := strconv.ParseUint(nonce, 10, 32)
+	newBlock.Nonce = uint32(tmp)
+	blockChain = append(blockChain, newBlock)
 
-				returnedInk, err2 := SvgHelper.RemoveShapeFromMap(operations[i].ShapeCommand, args.ArtNodePK,
-					operations[i].ShapeFill, previousMap)
+	if err := waitForConfirmations(args.RequestID, lastOne, args.ValidateNum); err != nil {
+		return err
+	}
+	reply.BlockHash = blockHash
+	reply.InkRemaining = uint32(remainInk - spentInk)
+	return err1
+}
 
-				incAcc.InkRemain = incAcc.InkRemain + uint32(returnedInk)
-				fmt.Println("@@@ADD23DD")
+// Checks the shape against the canvas of the last block without adding it.
+// Reply has the ink the shape would cost, if it is inside the canvas, and
+// the hash of the first shape it would overlap.
+func (m *MinerRPC) EstimateShape(args AddShapeStruct, reply *SvgHelper.ShapeEstimate) (err error) {
+	defer envelopeError(&err)
+	strokeWidth := int(args.StrokeWidth)
+	if strokeWidth == 0 {
+		strokeWidth = 1
+	}
+	if len(blockChain) == 0 {
+		return errors.New("Miner has no blocks yet")
+	}
+	path, _, err := SvgHelper.ShapeElement(int(args.SType), args.ShapeSvgString, args.Params, args.Fill, args.Stroke, strokeWidth)
+	if err != nil {
+		return err
+	}
+	shapes := blockChain[len(blockChain)-1].CanvasShapes
+	estimate, err := SvgHelper.EstimateShape(path, args.ArtNodePK, args.Fill, strokeWidth,
+		SvgHelper.CanvasSettings(settings.CanvasSettings), shapes, tipShapeIndex())
+	if err != nil {
+		return err
+	}
+	*reply = estimate
+	return nil
+}
 
-				incAcc.InkSpent = incAcc.InkSpent - uint32(returnedInk)
+func (m *MinerRPC) GetSvgString(shapeHash string, svgString *string) (err error) {
+	defer envelopeError(&err)
+	// latest op wins, a moved shape has its add op followed by move ops
+	for b := len(blockChain) - 1; b >= 0; b-- {
+		operations := blockChain[b].Ops
+		for i := len(operations) - 1; i >= 0; i-- {
+			if operations[i].OpSig == shapeHash && operations[i].AppShape != "delete" {
+				*svgString = operations[i].AppShape // svgString
+				return nil
+			}
+		}
+	}
+	return InvalidShapeHashError(shapeHash)
+}
 
-				mInks[globalPubKeyStr] = incAcc
+// Returns the shapes on the canvas at the block, in the order they were
+// added. An empty block hash means the last block of the longest chain.
+func (m *MinerRPC) GetCanvas(blockHash string, reply *[]CanvasShape) (err error) {
+	defer envelopeError(&err)
+	chain := blockChain
+	if len(chain) == 0 {
+		return InvalidBlockHashError(blockHash)
+	}
+	last := len(chain) - 1
+	if blockHash != "" {
+		last = blockIndex(chain, blockHash)
+		if last < 0 {
+			return InvalidBlockHashError(blockHash)
+		}
+	}
+	*reply = canvasShapes(chain, last)
+	return nil
+}
 
-				canvOps := blockChain[lastOne].CanvasOperations
-				myOps := canvOps[globalPubKeyStr]
-				svgAndHash := "delete:" + args.shapeHash
-				myOps = append(myOps, svgAndHash)
-				canvOps[globalPubKeyStr] = myOps
-				newBlock = Block{preHash, 0, newOps, false, globalPubKeyStr, lastOne + 1, mInks,
-					previousMap, canvOps} // need update CanvasInks globalPubKeyStr
-				_, nonce := calculateHash(newBlock, settings.PoWDifficultyOpBlock)
-				tmp, _ := strconv.ParseUint(nonce, 10, 32)
-				newBlock.Nonce = uint32(tmp)
-				blockChain = append(blockChain, newBlock)
+// Returns the shapes live after block last of the chain, in the order
+// they were first added.
+func canvasShapes(chain []Block, last int) []CanvasShape {
+	canvas := SvgHelper.CanvasSettings(settings.CanvasSettings)
+	live := make(map[string]CanvasShape)
+	var order []string
+	for i := 0; i <= last; i++ {
+		start := 0
+		if i > 0 {
+			start = len(chain[i-1].Ops)
+		}
+		ops := chain[i].Ops
+		for k := start; k < len(ops); k++ {
+			op := ops[k]
+			if op.AppShape == "delete" {
+				delete(live, op.OpSig)
+				continue
+			}
+			shape, exist := live[op.OpSig]
+			if !exist {
+				shape = CanvasShape{ShapeHash: op.OpSig, Owner: op.PubKeyArtNode, BlockHash: blockHashAt(chain, i)}
+				order = append(order, op.OpSig)
+			}
+			ink, _ := SvgHelper.InkCost(op.ShapeCommand, op.ShapeFill, op.ShapeStrokeWidth, canvas)
+			shape.SvgString, shape.Path = op.AppShape, op.ShapeCommand
+			shape.Fill, shape.Stroke = op.ShapeFill, op.ShapeStroke
+			shape.StrokeWidth, shape.InkCost = op.ShapeStrokeWidth, uint32(ink)
+			live[op.OpSig] = shape
+		}
+	}
+	shapes := make([]CanvasShape, 0, len(live))
+	for _, hash := range order {
+		if shape, exist := live[hash]; exist {
+			shapes = append(shapes, shape)
+			// a shape deleted and added again is listed once
+			delete(live, hash)
+		}
+	}
+	return shapes
+}
 
-				for {
-					last := len(blockChain) - 1
-					if last > lastOne+int(args.validateNum) {
-						break
-=======
+// Returns the index of the block with the given hash in the chain, -1 if
+// no block of the chain has that hash.
+func blockIndex(chain []Block, blockHash string) int {
+	for i := 0; i+1 < len(chain); i++ {
+		if chain[i+1].PrevHash == blockHash {
+			return i
+		}
+	}
+	if blockHashAt(chain, len(chain)-1) == blockHash {
+		return len(chain) - 1
+	}
+	return -1
+}
+
+func (m *MinerRPC) DeleteShape(args DelShapeArgs, inkRemaining *uint32) (err error) {
+	defer envelopeError(&err)
+	defer startRequest(args.RequestID)()
+	// try delete shape by args
+	lastOne := len(blockChain) - 1
+	if lastOne < 0 {
+		return InvalidShapeHashError(args.ShapeHash)
+	}
 
 	fmt.Print("##KKK(99999)6KK", len(blockChain))
-	for k := 1; k < lastOne; k++ {
+	for k := 0; k <= lastOne; k++ {
 		operations := blockChain[k].Ops
 		for i := 0; i < len(operations); i++ {
 			// fmt.Print(args.ShapeHash, "##KKK6666666KK", operations[i].OpSig)
@@ -847,14 +1288,13 @@ func (m *MinerRPC) DeleteShape(args DelShapeArgs, inkRemaining *uint32) error {
 				if args.ArtNodePK == operations[i].PubKeyArtNode {
 
 					fmt.Println("##KKKKKKKdelete")
-					newOp := Operation{"delete", args.ShapeHash, args.ArtNodePK, "", ""}
+					newOp := Operation{"delete", args.ShapeHash, args.ArtNodePK, "", "", "", 0}
 					newBlock, _ := generateBlock(blockChain[lastOne])
 					var noOp uint8
 					if blockChain[lastOne].NoOpBlock {
 						noOp = settings.PoWDifficultyNoOpBlock
 					} else {
 						noOp = settings.PoWDifficultyOpBlock
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 					}
 					preHash, _ := calculateHash(blockChain[lastOne], noOp)
 
@@ -864,15 +1304,14 @@ func (m *MinerRPC) DeleteShape(args DelShapeArgs, inkRemaining *uint32) error {
 					lastBlk := blockChain[lastOne]
 					mInks := lastBlk.MinerInks
 					incAcc := mInks[globalPubKeyStr]
-					previousMap := lastBlk.CanvasInks
+					previousMap := lastBlk.CanvasShapes
 
-					returnedInk, err2 := SvgHelper.RemoveShapeFromMap(operations[i].ShapeCommand, args.ArtNodePK,
-						operations[i].ShapeFill, previousMap)
+					returnedInk, err2 := SvgHelper.RemoveShapeFromMap(args.ShapeHash, args.ArtNodePK,
+						SvgHelper.CanvasSettings(settings.CanvasSettings), previousMap, tipShapeIndex())
 
-					incAcc.inkRemain = incAcc.inkRemain + uint32(returnedInk)
-					fmt.Println("@@@ADD23DD")
+					incAcc.InkRemain = incAcc.InkRemain + uint32(returnedInk)
 
-					incAcc.inkSpent = incAcc.inkSpent - uint32(returnedInk)
+					incAcc.InkSpent = incAcc.InkSpent - uint32(returnedInk)
 
 					mInks[globalPubKeyStr] = incAcc
 
@@ -882,84 +1321,381 @@ func (m *MinerRPC) DeleteShape(args DelShapeArgs, inkRemaining *uint32) error {
 					myOps = append(myOps, svgAndHash)
 					canvOps[globalPubKeyStr] = myOps
 					newBlock = Block{preHash, 0, newOps, false, globalPubKeyStr, lastOne + 1, mInks,
-						previousMap, canvOps} // need update CanvasInks globalPubKeyStr
+						previousMap, canvOps}
 					_, nonce := calculateHash(newBlock, settings.PoWDifficultyOpBlock)
 					tmp, _ := strconv.ParseUint(nonce, 10, 32)
 					newBlock.Nonce = uint32(tmp)
 					blockChain = append(blockChain, newBlock)
 
-					for {
-						last := len(blockChain) - 1
-						if last > lastOne+int(args.ValidateNum) {
-							break
-						}
-						time.Sleep(3 * time.Second)
+					if err := waitForConfirmations(args.RequestID, lastOne, args.ValidateNum); err != nil {
+						return err
 					}
 					ink := blockChain[lastOne].MinerInks[globalPubKeyStr]
 
-<<<<<<< HEAD
-				*inkRemaining = ink.InkRemain
-				return err2
-=======
-					*inkRemaining = ink.inkRemain
+					*inkRemaining = ink.InkRemain
 					return err2
 				}
 				return ShapeOwnerError(args.ShapeHash)
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 			}
 		}
 	}
 
-	fmt.Println("@@@ DeleteShape")
 	return InvalidShapeHashError(args.ShapeHash)
 }
 
-func (m *MinerRPC) GetShapes(blockHash string, shapeHashes *[]string) error {
-	// get shapeHashes
-	fmt.Println("@@@ GetShapes")
+// Moves a shape of the art node by Dx, Dy. The move is one op with the
+// svg element at the new position and keeps the shape hash; it costs no ink.
+func (m *MinerRPC) MoveShape(args MoveShapeArgs, reply *MoveShapeReply) (err error) {
+	defer envelopeError(&err)
+	defer startRequest(args.RequestID)()
 	lastOne := len(blockChain) - 1
 	if lastOne < 0 {
-		return InvalidBlockHashError(blockHash)
+		return InvalidShapeHashError(args.ShapeHash)
+	}
+	var lastOp *Operation
+	for _, blk := range blockChain {
+		for i := range blk.Ops {
+			if blk.Ops[i].OpSig == args.ShapeHash && blk.Ops[i].AppShape != "delete" {
+				lastOp = &blk.Ops[i]
+			}
+		}
 	}
+	lastBlk := blockChain[lastOne]
+	previousMap := lastBlk.CanvasShapes
+	// a shape deleted since still has its ops, but is not on the canvas
+	if _, live := previousMap[args.ShapeHash]; lastOp == nil || !live {
+		return InvalidShapeHashError(args.ShapeHash)
+	}
+	if lastOp.PubKeyArtNode != args.ArtNodePK {
+		return ShapeOwnerError(args.ShapeHash)
+	}
+	path, err := SvgHelper.MoveShapeInMap(args.ShapeHash, args.ArtNodePK, args.Dx, args.Dy,
+		SvgHelper.CanvasSettings(settings.CanvasSettings), previousMap, tipShapeIndex())
+	if err != nil {
+		return err
+	}
+	svgStr := translateElement(lastOp.AppShape, path, args.Dx, args.Dy)
+	newOp := Operation{svgStr, args.ShapeHash, args.ArtNodePK, path, lastOp.ShapeFill, lastOp.ShapeStroke,
+		lastOp.ShapeStrokeWidth}
+
+	newBlock, err1 := generateBlock(lastBlk)
 	var noOp uint8
-	if blockChain[lastOne].NoOpBlock {
+	if lastBlk.NoOpBlock {
 		noOp = settings.PoWDifficultyNoOpBlock
 	} else {
 		noOp = settings.PoWDifficultyOpBlock
 	}
-	lastblockHash, _ := calculateHash(blockChain[lastOne], noOp)
-	if lastblockHash == blockHash {
-		ops := blockChain[lastOne].Ops
-		for j := 0; j < len(ops); j++ {
-			(*shapeHashes)[j] = ops[j].OpSig
-		}
-		return nil
+	preHash, _ := calculateHash(lastBlk, noOp)
+	newOps := append(lastBlk.Ops, newOp)
+
+	canvOps := lastBlk.CanvasOperations
+	canvOps[globalPubKeyStr] = append(canvOps[globalPubKeyStr], svgStr+":"+args.ShapeHash)
+	newBlock = Block{preHash, 0, newOps, false, globalPubKeyStr, lastOne + 1, lastBlk.MinerInks,
+		previousMap, canvOps}
+	blockHash, nonce := calculateHash(newBlock, settings.PoWDifficultyOpBlock)
+	tmp, _ := strconv.ParseUint(nonce, 10, 32)
+	newBlock.Nonce = uint32(tmp)
+	blockChain = append(blockChain, newBlock)
+
+	if err := waitForConfirmations(args.RequestID, lastOne, args.ValidateNum); err != nil {
+		return err
 	}
-	for i := len(blockChain) - 1; i >= 0; i-- {
-		if blockChain[i].PrevHash == blockHash {
-			ops := blockChain[i-1].Ops
-			for j := 0; j < len(ops); j++ {
-				(*shapeHashes)[j] = ops[j].OpSig
+	*reply = MoveShapeReply{blockHash, minerInkRemain()}
+	return err1
+}
+
+// svg attribute and its value
+var svgAttribute = regexp.MustCompile(`([a-z-]+)="([^"]*)"`)
+
+// Returns the vertices of the points attribute of a polygon or polyline.
+func parsePoints(value string) []SvgHelper.Vertex {
+	var points []SvgHelper.Vertex
+	for _, pair := range strings.Fields(value) {
+		xy := strings.Split(pair, ",")
+		x, _ := strconv.Atoi(xy[0])
+		y, _ := strconv.Atoi(xy[len(xy)-1])
+		points = append(points, SvgHelper.Vertex{X: x, Y: y})
+	}
+	return points
+}
+
+// Returns the svg element moved by dx, dy: a path gets the moved svg path,
+// the other shape elements get their position attributes moved.
+func translateElement(element string, path string, dx int, dy int) string {
+	return svgAttribute.ReplaceAllStringFunc(element, func(attr string) string {
+		match := svgAttribute.FindStringSubmatch(attr)
+		name, value := match[1], match[2]
+		switch name {
+		case "d":
+			value = path
+		case "x", "cx":
+			n, _ := strconv.Atoi(value)
+			value = strconv.Itoa(n + dx)
+		case "y", "cy":
+			n, _ := strconv.Atoi(value)
+			value = strconv.Itoa(n + dy)
+		case "points":
+			points := parsePoints(value)
+			for i := range points {
+				points[i].X, points[i].Y = points[i].X+dx, points[i].Y+dy
+			}
+			value = SvgHelper.PointsAttribute(points)
+		}
+		return name + "=\"" + value + "\""
+	})
+}
+
+// Returns the block of the miner's chain that added the shape and the
+// number of blocks after it. Returns InvalidShapeHashError if no block of
+// the chain has the shape, e.g. after the miner took a chain without it.
+// A shape deleted or moved since is still found at the block that added it.
+func (m *MinerRPC) GetShapeStatus(shapeHash string, reply *ShapeStatusReply) (err error) {
+	defer envelopeError(&err)
+	// an op block repeats the ops of the op block before it, so the first
+	// block with the op is the one that added it
+	for i, blk := range blockChain {
+		for _, op := range blk.Ops {
+			if op.OpSig == shapeHash && op.AppShape != "delete" {
+				*reply = ShapeStatusReply{blockHashAt(blockChain, i), len(blockChain) - 1 - i}
+				return nil
 			}
+		}
+	}
+	return InvalidShapeHashError(shapeHash)
+}
+
+// How long WatchCanvas waits for new events before replying with none.
+const watchTimeout = 30 * time.Second
+
+// Returns the events after args.After, waiting for new ones if there are
+// none yet. An art node streams the canvas by calling it in a loop with the
+// Seq of the last event it got.
+func (m *MinerRPC) WatchCanvas(args WatchArgs, reply *WatchReply) (err error) {
+	defer envelopeError(&err)
+	timeout := time.After(watchTimeout)
+	for {
+		canvasEvents.Lock()
+		if args.After+1 < canvasEvents.first {
+			canvasEvents.Unlock()
+			return errors.New("Events after " + strconv.Itoa(args.After) + " were dropped")
+		}
+		start := args.After + 1 - canvasEvents.first
+		if start < len(canvasEvents.events) {
+			reply.Events = append([]CanvasEvent(nil), canvasEvents.events[start:]...)
+			canvasEvents.Unlock()
+			return nil
+		}
+		changed := canvasEvents.changed
+		canvasEvents.Unlock()
+		select {
+		case <-changed:
+		case <-timeout:
 			return nil
 		}
 	}
-	return InvalidBlockHashError(blockHash)
 }
 
-func (m *MinerRPC) GetGenesisBlock(args int, blockHash *string) error {
+// Checks the block chain every second and logs the events of the blocks
+// that joined the longest chain since the last check, after retracting the
+// events of the blocks that left it.
+func publishCanvasEvents() {
+	for {
+		time.Sleep(time.Second)
+		if len(blockChain) == 0 {
+			continue
+		}
+		chain := blockChain
+		hashes := make([]string, len(chain))
+		for i := 0; i+1 < len(chain); i++ {
+			hashes[i] = chain[i+1].PrevHash
+		}
+		canvasEvents.Lock()
+		n := len(canvasEvents.announced)
+		if n == len(chain) && n > 1 && canvasEvents.announced[n-2] == hashes[n-2] {
+			// same chain as last time, no need to hash the tip again
+			canvasEvents.Unlock()
+			continue
+		}
+		canvasEvents.Unlock()
+		hashes[len(chain)-1] = blockHashAt(chain, len(chain)-1)
+		logChainEvents(chain, hashes)
+	}
+}
+
+// Brings the event log from the announced chain to the given chain.
+func logChainEvents(chain []Block, hashes []string) {
+	canvasEvents.Lock()
+	defer canvasEvents.Unlock()
+	el := &canvasEvents
+	common := 0
+	for common < len(el.announced) && common < len(hashes) && el.announced[common] == hashes[common] {
+		common++
+	}
+	if common == len(el.announced) && common == len(hashes) {
+		return
+	}
+	var events []CanvasEvent
+	for i := len(el.announced) - 1; i >= common; i-- {
+		blockEvents := el.blockEvents[i]
+		for j := len(blockEvents) - 1; j >= 0; j-- {
+			e := blockEvents[j]
+			e.Retracted = true
+			events = append(events, e)
+		}
+	}
+	el.announced = el.announced[:common]
+	el.blockEvents = el.blockEvents[:common]
+	for i := common; i < len(chain); i++ {
+		blockEvents := opEvents(chain, i, hashes[i])
+		events = append(events, blockEvents...)
+		el.announced = append(el.announced, hashes[i])
+		el.blockEvents = append(el.blockEvents, blockEvents)
+	}
+	for _, e := range events {
+		e.Seq = el.first + len(el.events)
+		el.events = append(el.events, e)
+	}
+	if drop := len(el.events) - maxCanvasEvents; drop > 0 {
+		el.events = append([]CanvasEvent(nil), el.events[drop:]...)
+		el.first += drop
+	}
+	close(el.changed)
+	el.changed = make(chan struct{})
+}
+
+// Events of the ops block i of the chain added. The ops of a block start
+// with the ops of the block before it.
+func opEvents(chain []Block, i int, blockHash string) []CanvasEvent {
+	start := 0
+	if i > 0 {
+		start = len(chain[i-1].Ops)
+	}
+	ops := chain[i].Ops
+	var events []CanvasEvent
+	for k := start; k < len(ops); k++ {
+		op := ops[k]
+		e := CanvasEvent{Kind: opKind(ops, k), ShapeHash: op.OpSig, SvgString: op.AppShape, Owner: op.PubKeyArtNode, BlockHash: blockHash}
+		if e.Kind == "delete" {
+			e.SvgString = ""
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// Returns "add", "delete" or "move" for op k of ops, which holds every op
+// of the chain up to it.
+func opKind(ops []Operation, k int) string {
+	if ops[k].AppShape == "delete" {
+		return "delete"
+	}
+	// a move follows an op that left the shape on the canvas
+	for j := k - 1; j >= 0; j-- {
+		if ops[j].OpSig == ops[k].OpSig {
+			if ops[j].AppShape != "delete" {
+				return "move"
+			}
+			break
+		}
+	}
+	return "add"
+}
+
+// Returns the header and ops of the block with the given hash on the
+// longest chain.
+func (m *MinerRPC) GetBlock(blockHash string, reply *BlockInfo) (err error) {
+	defer envelopeError(&err)
+	if blockHash == settings.GenesisBlockHash {
+		*reply = BlockInfo{BlockHash: blockHash, Height: 0, NoOpBlock: true}
+		return nil
+	}
+	chain := blockChain
+	if len(chain) == 0 {
+		return InvalidBlockHashError(blockHash)
+	}
+	i := blockIndex(chain, blockHash)
+	if i < 0 {
+		return InvalidBlockHashError(blockHash)
+	}
+	b := chain[i]
+	info := BlockInfo{BlockHash: blockHash, PrevHash: b.PrevHash, Nonce: b.Nonce, Height: i + 1,
+		MinerKey: b.PubKeyMiner, NoOpBlock: b.NoOpBlock, InkAwarded: settings.InkPerOpBlock}
+	if b.NoOpBlock {
+		info.InkAwarded = settings.InkPerNoOpBlock
+	}
+	start := 0
+	if i > 0 {
+		start = len(chain[i-1].Ops)
+	}
+	canvas := SvgHelper.CanvasSettings(settings.CanvasSettings)
+	for k := start; k < len(b.Ops); k++ {
+		op := BlockOp{Kind: opKind(b.Ops, k), ShapeHash: b.Ops[k].OpSig, Owner: b.Ops[k].PubKeyArtNode}
+		if op.Kind == "add" {
+			ink, _ := SvgHelper.InkCost(b.Ops[k].ShapeCommand, b.Ops[k].ShapeFill, b.Ops[k].ShapeStrokeWidth, canvas)
+			op.InkCost = uint32(ink)
+		}
+		info.Ops = append(info.Ops, op)
+	}
+	*reply = info
+	return nil
+}
+
+// Returns the hash of the block at index i of the chain.
+func blockHashAt(chain []Block, i int) string {
+	if i+1 < len(chain) {
+		return chain[i+1].PrevHash
+	}
+	difficulty := settings.PoWDifficultyOpBlock
+	if chain[i].NoOpBlock {
+		difficulty = settings.PoWDifficultyNoOpBlock
+	}
+	hash, _ := calculateHash(chain[i], difficulty)
+	return hash
+}
+
+// Returns the hashes of the shape ops of the block with the given hash on
+// the longest chain.
+func (m *MinerRPC) GetShapes(blockHash string, shapeHashes *[]string) (err error) {
+	defer envelopeError(&err)
+	// get shapeHashes
+	*shapeHashes = []string{}
+	if blockHash == settings.GenesisBlockHash {
+		return nil
+	}
+	chain := blockChain
+	if len(chain) == 0 {
+		return InvalidBlockHashError(blockHash)
+	}
+	i := blockIndex(chain, blockHash)
+	if i < 0 {
+		return InvalidBlockHashError(blockHash)
+	}
+	// ops are cumulative, the block's own start after its parent's
+	start := 0
+	if i > 0 {
+		start = len(chain[i-1].Ops)
+	}
+	ops := chain[i].Ops
+	for j := start; j < len(ops); j++ {
+		*shapeHashes = append(*shapeHashes, ops[j].OpSig)
+	}
+	return nil
+}
+
+func (m *MinerRPC) GetGenesisBlock(args int, blockHash *string) (err error) {
+	defer envelopeError(&err)
 	*blockHash = settings.GenesisBlockHash
 	return nil
 }
 
-func (m *MinerRPC) GetChildren(blockHash string, blockHashes *[]string) error {
+func (m *MinerRPC) GetChildren(blockHash string, blockHashes *[]string) (err error) {
+	defer envelopeError(&err)
 	// blockHashes = children of blockHash
 
 	lastOne := len(blockChain) - 1
 	if lastOne < 0 {
 		return InvalidBlockHashError(blockHash)
 	}
-	fmt.Println("@@@ GetChildren")
 	var strs []string
 	for i := len(blockChain) - 1; i >= 0; i-- {
 		if blockChain[i].PrevHash == blockHash {
@@ -978,25 +1714,305 @@ func (m *MinerRPC) GetChildren(blockHash string, blockHashes *[]string) error {
 	return InvalidBlockHashError(blockHash)
 }
 
-func (m *MinerRPC) CloseCanvas(args int, reply *CloseCanvReply) error {
-	fmt.Println("@@@ CloseCanvas")
+func (m *MinerRPC) CloseCanvas(args int, reply *CloseCanvReply) (err error) {
+	defer envelopeError(&err)
 	lastOne := len(blockChain) - 1
 	if lastOne < 0 {
-		*reply = CloseCanvReply{inkRemaining: 0}
+		*reply = CloseCanvReply{}
 		return nil
 	}
-	ink := blockChain[lastOne].MinerInks[myKeyPairInString]
+	ink := blockChain[lastOne].MinerInks[globalPubKeyStr]
 
-<<<<<<< HEAD
-	*reply = CloseCanvReply{blockChain[lastOne].Ops, ink.InkRemain}
-	fmt.Println("@@@ CloseCanvas")
-=======
-	*reply = CloseCanvReply{blockChain[lastOne].CanvasOperations, ink.inkRemain}
+	*reply = CloseCanvReply{canvasShapes(blockChain, lastOne), ink.InkRemain}
 
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 	return nil
 }
 
+/*********************************
+HTTP/JSON gateway for Artnodes
+*********************************/
+// The gateway offers the art node RPCs as JSON endpoints for clients that
+// do not speak gob:
+//   POST   /shapes                 body: AddShapeStruct, reply: AddShapeReply
+//   GET    /shapes/{hash}          reply: svg string
+//   DELETE /shapes/{hash}?validateNum=N   reply: ink remaining
+//   GET    /ink                    reply: ink remaining
+//   GET    /canvas?block={hash}    reply: []CanvasShape, tip if no block
+//   GET    /genesis                reply: block hash
+//   GET    /blocks/{hash}          reply: BlockInfo
+//   GET    /blocks/{hash}/shapes   reply: shape hashes
+//   GET    /blocks/{hash}/children reply: block hashes
+// Every request carries the miner's private key in the X-Miner-Key header,
+// as Connect checks it. Shape ops also carry the key the art node owns its
+// shapes with in X-Art-Node-Key. Errors are replied with the status of
+// their code in gatewayStatus and the ErrorEnvelope as the body.
+
+// HTTP status of each error code, 500 for errors without a code.
+var gatewayStatus = map[string]int{
+	"InsufficientInk":       http.StatusPaymentRequired,
+	"OutOfBounds":           http.StatusUnprocessableEntity,
+	"ShapeOverlap":          http.StatusConflict,
+	"ShapeOwner":            http.StatusForbidden,
+	"InvalidShapeSvgString": http.StatusBadRequest,
+	"ShapeSvgStringTooLong": http.StatusBadRequest,
+	"InvalidColour":         http.StatusBadRequest,
+	"InvalidShapeHash":      http.StatusNotFound,
+	"InvalidBlockHash":      http.StatusNotFound,
+	"InvalidMinerPK":        http.StatusUnauthorized,
+}
+
+// Serves the gateway on addr, a port on 127.0.0.1 or a host:port.
+func listenToHTTP(addr string) {
+	if !strings.Contains(addr, ":") {
+		addr = "127.0.0.1:" + addr
+	}
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		log.Fatal("listen error:", e)
+	}
+	go http.Serve(l, http.HandlerFunc(serveGateway))
+}
+
+func serveGateway(w http.ResponseWriter, r *http.Request) {
+	m := new(MinerRPC)
+	if key := r.Header.Get("X-Miner-Key"); key != myKeyPairInString {
+		writeGatewayError(w, InvalidMinerPKError(key))
+		return
+	}
+	artNodePK := r.Header.Get("X-Art-Node-Key")
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	var reply interface{}
+	var err error
+	switch {
+	case r.Method == "POST" && len(path) == 1 && path[0] == "shapes":
+		var args AddShapeStruct
+		if err = json.NewDecoder(r.Body).Decode(&args); err != nil {
+			err = SvgHelper.InvalidShapeSvgStringError("bad request body: " + err.Error())
+			break
+		}
+		if artNodePK == "" {
+			writeGatewayStatus(w, http.StatusUnauthorized, ErrorEnvelope{Message: "missing X-Art-Node-Key header"})
+			return
+		}
+		args.ArtNodePK, args.RequestID = artNodePK, newRequestID()
+		defer cancelOnDisconnect(r, args.RequestID)()
+		var shape AddShapeReply
+		err, reply = m.AddShape(args, &shape), &shape
+	case r.Method == "GET" && len(path) == 2 && path[0] == "shapes":
+		var svg string
+		err, reply = m.GetSvgString(path[1], &svg), &svg
+	case r.Method == "DELETE" && len(path) == 2 && path[0] == "shapes":
+		if artNodePK == "" {
+			writeGatewayStatus(w, http.StatusUnauthorized, ErrorEnvelope{Message: "missing X-Art-Node-Key header"})
+			return
+		}
+		validateNum, _ := strconv.Atoi(r.URL.Query().Get("validateNum"))
+		args := DelShapeArgs{uint8(validateNum), path[1], artNodePK, newRequestID()}
+		defer cancelOnDisconnect(r, args.RequestID)()
+		var ink uint32
+		err, reply = m.DeleteShape(args, &ink), &ink
+	case r.Method == "GET" && len(path) == 1 && path[0] == "ink":
+		var ink uint32
+		err, reply = m.GetInk(myKeyPairInString, &ink), &ink
+	case r.Method == "GET" && len(path) == 1 && path[0] == "canvas":
+		var shapes []CanvasShape
+		err, reply = m.GetCanvas(r.URL.Query().Get("block"), &shapes), &shapes
+	case r.Method == "GET" && len(path) == 1 && path[0] == "genesis":
+		var hash string
+		err, reply = m.GetGenesisBlock(0, &hash), &hash
+	case r.Method == "GET" && len(path) == 2 && path[0] == "blocks":
+		var block BlockInfo
+		err, reply = m.GetBlock(path[1], &block), &block
+	case r.Method == "GET" && len(path) == 3 && path[0] == "blocks" && path[2] == "shapes":
+		hashes := []string{}
+		err, reply = m.GetShapes(path[1], &hashes), &hashes
+	case r.Method == "GET" && len(path) == 3 && path[0] == "blocks" && path[2] == "children":
+		hashes := []string{}
+		err, reply = m.GetChildren(path[1], &hashes), &hashes
+	default:
+		writeGatewayStatus(w, http.StatusNotFound, ErrorEnvelope{Message: r.Method + " " + r.URL.Path + " not found"})
+		return
+	}
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	writeGatewayStatus(w, http.StatusOK, reply)
+}
+
+// Stops the request from waiting for confirmations if the client goes away.
+// Returns the function to call when the request is done.
+func cancelOnDisconnect(r *http.Request, requestID string) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-r.Context().Done():
+			select {
+			case <-done:
+			default:
+				var ok bool
+				new(MinerRPC).CancelRequest(requestID, &ok)
+			}
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func writeGatewayError(w http.ResponseWriter, err error) {
+	envelopeError(&err)
+	e, _ := err.(ErrorEnvelope)
+	status, exist := gatewayStatus[e.Code]
+	if !exist {
+		status = http.StatusInternalServerError
+	}
+	writeGatewayStatus(w, status, e)
+}
+
+func writeGatewayStatus(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+/*********************************
+Live web view of the canvas
+*********************************/
+// The view is a local web page with the canvas of the longest chain as svg.
+// The page listens on /events, a server-sent event stream that sends the
+// whole canvas as a canvasView every time the tip changes. Hovering over a
+// shape shows its owner and the block that added it.
+
+// Canvas at the tip of the longest chain, as the view draws it.
+type canvasView struct {
+	BlockHash string
+	Width     uint32
+	Height    uint32
+	Shapes    []CanvasShape
+}
+
+// how often the event stream sends a comment to keep the connection open
+const viewKeepAlive = 15 * time.Second
+
+// Serves the view on port of 127.0.0.1.
+func listenToView(port string) {
+	l, e := net.Listen("tcp", "127.0.0.1:"+port)
+	if e != nil {
+		log.Fatal("listen error:", e)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, canvasViewPage)
+	})
+	mux.HandleFunc("/events", serveCanvasEvents)
+	go http.Serve(l, mux)
+}
+
+// Streams the canvas every time the tip of the longest chain changes,
+// starting with the current one.
+func serveCanvasEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	keepAlive := time.NewTicker(viewKeepAlive)
+	defer keepAlive.Stop()
+	sent := ""
+	for {
+		canvasEvents.Lock()
+		changed := canvasEvents.changed
+		tip := ""
+		if n := len(canvasEvents.announced); n > 0 {
+			tip = canvasEvents.announced[n-1]
+		}
+		canvasEvents.Unlock()
+		if tip != "" && tip != sent {
+			chain := blockChain
+			if i := blockIndex(chain, tip); i >= 0 {
+				view := canvasView{tip, settings.CanvasSettings.CanvasXMax, settings.CanvasSettings.CanvasYMax,
+					canvasShapes(chain, i)}
+				data, _ := json.Marshal(view)
+				fmt.Fprintf(w, "event: canvas\ndata: %s\n\n", data)
+				flusher.Flush()
+				sent = tip
+			}
+		}
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+const canvasViewPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>BlockArt canvas</title>
+<style>
+  body { font-family: sans-serif; margin: 1em; }
+  svg { border: 1px solid #ccc; background: white; }
+  svg g:hover { opacity: 0.6; }
+  #info { font-family: monospace; white-space: pre-wrap; word-break: break-all; min-height: 3em; }
+</style>
+</head>
+<body>
+<h1>BlockArt canvas</h1>
+<p id="tip">Waiting for the miner...</p>
+<div id="canvas"></div>
+<p id="info"></p>
+<script>
+var SVGNS = "http://www.w3.org/2000/svg";
+var events = new EventSource("/events");
+events.addEventListener("canvas", function (msg) {
+  var view = JSON.parse(msg.data);
+  document.getElementById("tip").textContent = "Tip: " + view.BlockHash +
+    " (" + (view.Shapes || []).length + " shapes)";
+  var svg = document.createElementNS(SVGNS, "svg");
+  svg.setAttribute("width", view.Width);
+  svg.setAttribute("height", view.Height);
+  svg.setAttribute("viewBox", "0 0 " + view.Width + " " + view.Height);
+  (view.Shapes || []).forEach(function (shape) {
+    var g = document.createElementNS(SVGNS, "g");
+    g.innerHTML = shape.SvgString;
+    var title = document.createElementNS(SVGNS, "title");
+    title.textContent = "owner " + shape.Owner.slice(0, 16) + "...\nblock " + shape.BlockHash;
+    g.appendChild(title);
+    g.addEventListener("mouseover", function () {
+      document.getElementById("info").textContent = "shape " + shape.ShapeHash +
+        "\nowner " + shape.Owner + "\nblock " + shape.BlockHash + "\nink " + shape.InkCost;
+    });
+    svg.appendChild(g);
+  });
+  var canvas = document.getElementById("canvas");
+  canvas.replaceChildren(svg);
+});
+events.onerror = function () {
+  document.getElementById("tip").textContent = "Lost the miner, reconnecting...";
+};
+</script>
+</body>
+</html>
+`
+
 /*********************************
 RPC calls for inkMIner to inkMiner
 *********************************/
@@ -1025,14 +2041,9 @@ func (m *MinerToMinerRPC) EstablishReverseRPC(addr string, reply *string) error
 	return nil
 }
 
-<<<<<<< HEAD
 func (m *MinerToMinerRPC) SendBlockChain(bc []Block, reply *string) error {
 	// 1. Check if the sent block is longer than our block.
 	fmt.Println("Inside sbc")
-=======
-func (m *MinerToMinerRPC) SendBlockchain(bc []Block, reply *string) error {
-	// 1. Check if the sent block is longer than our block.
->>>>>>> 2f26d3d8633216a784c852dd6b169cd431b9cc39
 	if isSentChainLonger(bc) {
 		fmt.Println("sbc: Received a longer chain than what we have.")
 		val := validateBlockChain(bc)
@@ -1042,6 +2053,7 @@ func (m *MinerToMinerRPC) SendBlockchain(bc []Block, reply *string) error {
 			// 2.2 Otherwise acquire the lock for global blockchain and set it to sent block
 			fmt.Println("sbc: longer chain is valid, we'll throw ours away")
 			blockChain = bc
+			canvasIndex = nil
 			*reply = strconv.FormatBool(true)
 			return nil
 		}
@@ -1321,11 +2333,78 @@ func validateBlockOpSigs(b Block) bool {
 	return true
 }
 
+// Given a block, determines whether every shape added by its operations
+// can be drawn on the canvas: inside the canvas, and for filled shapes
+// closed and without edges crossing each other
+func validateBlockShapes(b Block) bool {
+	canvas := SvgHelper.CanvasSettings(settings.CanvasSettings)
+	for _, op := range b.Ops {
+		if op.AppShape == "delete" {
+			continue
+		}
+		err := SvgHelper.ValidateShape(op.ShapeCommand, op.ShapeFill, op.ShapeStrokeWidth, canvas)
+		if err == nil {
+			err = SvgHelper.ValidateColour(op.ShapeFill)
+		}
+		if err == nil {
+			err = SvgHelper.ValidateColour(op.ShapeStroke)
+		}
+		if err != nil {
+			fmt.Println("vbs: ", err)
+			return false
+		}
+		if !validShapeElement(op) {
+			fmt.Println("vbs: bad svg element ", op.AppShape)
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the svg element of the op is the one SvgHelper.ShapeElement
+// builds for a shape with the geometry of the op's ShapeCommand, and the
+// colours and stroke width of the op. So the element draws the shape whose
+// bounds, overlaps and ink were checked, and nothing else can be smuggled
+// into the pages the canvas is drawn on.
+func validShapeElement(op Operation) bool {
+	tag := strings.TrimPrefix(strings.SplitN(op.AppShape, " ", 2)[0], "<")
+	attrs := make(map[string]string)
+	for _, match := range svgAttribute.FindAllStringSubmatch(op.AppShape, -1) {
+		attrs[match[1]] = match[2]
+	}
+	number := func(name string) int {
+		n, _ := strconv.Atoi(attrs[name])
+		return n
+	}
+	var shapeType ShapeType
+	var svg string
+	var p SvgHelper.ShapeParams
+	switch tag {
+	case "path":
+		shapeType, svg = PATH, attrs["d"]
+	case "rect":
+		shapeType = RECT
+		p.X, p.Y, p.Width, p.Height = number("x"), number("y"), number("width"), number("height")
+	case "ellipse":
+		shapeType = ELLIPSE
+		p.Cx, p.Cy, p.Rx, p.Ry = number("cx"), number("cy"), number("rx"), number("ry")
+	case "polygon":
+		shapeType, p.Points = POLYGON, parsePoints(attrs["points"])
+	case "polyline":
+		shapeType, p.Points = POLYLINE, parsePoints(attrs["points"])
+	default:
+		return false
+	}
+	path, element, err := SvgHelper.ShapeElement(int(shapeType), svg, p, op.ShapeFill, op.ShapeStroke, op.ShapeStrokeWidth)
+	return err == nil && element == op.AppShape && SvgHelper.SamePath(path, op.ShapeCommand)
+}
+
 // Traverses the given block chain, and determines its overall validity.
-// Validity is composed of 3 components:
+// Validity is composed of 4 components:
 //      (1) Block points to a previous legal block
 //      (2) Block has correct nonce proof-of-work
 //      (3) Block has correct operation signatures
+//      (4) Block only adds shapes that can be drawn on the canvas
 func validateBlockChain(bc []Block) bool {
 	var hashVal string
 	var boolValidNonce bool
@@ -1342,7 +2421,7 @@ func validateBlockChain(bc []Block) bool {
 		boolValidNonce, hashVal = validateBlockHashNonce(b)
 		boolValidOpSig = validateBlockOpSigs(b)
 
-		if !boolValidNonce || !boolValidOpSig {
+		if !boolValidNonce || !boolValidOpSig || !validateBlockShapes(b) {
 			return false
 		}
 	}
@@ -1,14 +1,393 @@
-package ink-miner
+package main
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"../SvgHelper"
 )
 
+// Starts the miner over with a new key, a 100x100 canvas and a chain of
+// noOps no-op blocks.
+func newTestMiner(t *testing.T, noOps int) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	myPrivKey = key
+	myKeyPairInString = hex.EncodeToString(keyBytes)
+	globalPubKeyStr = getPubKeyInStr(key.PublicKey)
+	settings = MinerNetSettings{
+		MinerSettings: MinerSettings{GenesisBlockHash: "83218ac34c1834c26781fe4bde918ee4",
+			InkPerOpBlock: 50, InkPerNoOpBlock: 100, PoWDifficultyOpBlock: 1, PoWDifficultyNoOpBlock: 1},
+		CanvasSettings: CanvasSettings{CanvasXMax: 100, CanvasYMax: 100},
+	}
+	blockChain = make([]Block, 0)
+	canvasIndex = nil
+	for i := 0; i < noOps; i++ {
+		mineNoOpBlocks(globalPubKeyStr)
+	}
+}
+
+func addTestShape(t *testing.T, svg string) AddShapeReply {
+	var reply AddShapeReply
+	err := new(MinerRPC).AddShape(AddShapeStruct{SType: PATH, ShapeSvgString: svg, Fill: "transparent",
+		Stroke: "red", ArtNodePK: "art-node"}, &reply)
+	if err != nil {
+		t.Fatalf("AddShape(%q): %v", svg, err)
+	}
+	return reply
+}
+
 func TestIntersect(t *testing.T) {
-	// test stuff here...
-	intersect = doesIntersect()
-	if intersect != false {
-		t.Error("Expected false, got: ", intersect)
+	newTestMiner(t, 3)
+	addTestShape(t, "M 0 0 L 0 5")
+	var reply AddShapeReply
+	err := new(MinerRPC).AddShape(AddShapeStruct{SType: PATH, ShapeSvgString: "M 10 0 L 10 5", Fill: "transparent",
+		Stroke: "red", ArtNodePK: "other-art-node"}, &reply)
+	if err != nil {
+		t.Error("Expected no intersection, got: ", err)
+	}
+	err = new(MinerRPC).AddShape(AddShapeStruct{SType: PATH, ShapeSvgString: "M 0 2 L 5 2", Fill: "transparent",
+		Stroke: "red", ArtNodePK: "other-art-node"}, &reply)
+	if err == nil {
+		t.Error("Expected an intersection, got none")
+	}
+}
+
+func gatewayRequest(t *testing.T, method string, path string, body string, reply interface{}) int {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("X-Miner-Key", myKeyPairInString)
+	r.Header.Set("X-Art-Node-Key", "art-node")
+	w := httptest.NewRecorder()
+	serveGateway(w, r)
+	if reply != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), reply); err != nil {
+			t.Fatalf("%s %s: %v in %q", method, path, err, w.Body.String())
+		}
+	}
+	return w.Code
+}
+
+func TestGatewayBlockShapes(t *testing.T) {
+	newTestMiner(t, 2)
+	noOpHash := blockHashAt(blockChain, 1)
+	first := addTestShape(t, "M 0 0 L 0 5")
+
+	var added AddShapeReply
+	status := gatewayRequest(t, "POST", "/shapes",
+		`{"SType": 0, "ShapeSvgString": "M 20 20 L 30 20", "Fill": "transparent", "Stroke": "blue"}`, &added)
+	if status != http.StatusOK {
+		t.Fatalf("POST /shapes: status %d", status)
+	}
+
+	var hashes []string
+	if status := gatewayRequest(t, "GET", "/blocks/"+added.BlockHash+"/shapes", "", &hashes); status != http.StatusOK {
+		t.Fatalf("GET shapes of op block: status %d", status)
+	}
+	if len(hashes) != 1 || hashes[0] != added.ShapeHash {
+		t.Errorf("shapes of op block: got %v, want [%s]", hashes, added.ShapeHash)
+	}
+	hashes = nil
+	gatewayRequest(t, "GET", "/blocks/"+first.BlockHash+"/shapes", "", &hashes)
+	if len(hashes) != 1 || hashes[0] != first.ShapeHash {
+		t.Errorf("shapes of first op block: got %v, want [%s]", hashes, first.ShapeHash)
+	}
+	hashes = nil
+	gatewayRequest(t, "GET", "/blocks/"+noOpHash+"/shapes", "", &hashes)
+	if hashes == nil || len(hashes) != 0 {
+		t.Errorf("shapes of no-op block: got %v, want []", hashes)
+	}
+
+	var e ErrorEnvelope
+	if status := gatewayRequest(t, "GET", "/blocks/nosuchblock/shapes", "", &e); status != http.StatusNotFound || e.Code != "InvalidBlockHash" {
+		t.Errorf("shapes of unknown block: status %d, code %q", status, e.Code)
+	}
+}
+
+// InsufficientInkError reaches the art node with the ink the miner has
+// left, as blockartlib decodes it.
+func TestInsufficientInkEnvelope(t *testing.T) {
+	newTestMiner(t, 1)
+	remaining := minerInkRemain()
+	var reply AddShapeReply
+	err := new(MinerRPC).AddShape(AddShapeStruct{SType: PATH, ShapeSvgString: "M 0 0 L 0 99 L 5 99", Fill: "transparent",
+		Stroke: "red", ArtNodePK: "art-node"}, &reply)
+	want := ErrorEnvelope{Code: "InsufficientInk", Payload: "100", Message: "BlockArt: Not enough ink to addShape [100]"}
+	if remaining != 100 || err != want {
+		t.Errorf("AddShape with %d ink: got %v, want %v", remaining, err, want)
+	}
+	var batchReply AddShapesReply
+	err = new(MinerRPC).AddShapes(AddShapesArgs{Shapes: []AddShapeStruct{
+		{SType: PATH, ShapeSvgString: "M 0 0 L 0 60", Fill: "transparent", Stroke: "red"},
+		{SType: PATH, ShapeSvgString: "M 10 0 L 10 60", Fill: "transparent", Stroke: "red"},
+	}, ArtNodePK: "art-node"}, &batchReply)
+	if err != want {
+		t.Errorf("AddShapes with %d ink: got %v, want %v", remaining, err, want)
+	}
+	// the text net/rpc sends, which blockartlib's tests decode
+	if text := want.Error(); text != `{"Code":"InsufficientInk","Payload":"100","Message":"BlockArt: Not enough ink to addShape [100]"}` {
+		t.Errorf("envelope text: got %s", text)
+	}
+}
+
+func TestGetShapeStatus(t *testing.T) {
+	newTestMiner(t, 1)
+	added := addTestShape(t, "M 0 0 L 0 5")
+	mineNoOpBlocks(globalPubKeyStr)
+	mineNoOpBlocks(globalPubKeyStr)
+	var status ShapeStatusReply
+	if err := new(MinerRPC).GetShapeStatus(added.ShapeHash, &status); err != nil || status != (ShapeStatusReply{added.BlockHash, 2}) {
+		t.Errorf("got %v %v, want block %s at depth 2", status, err, added.BlockHash)
+	}
+	var ink uint32
+	if err := new(MinerRPC).DeleteShape(DelShapeArgs{ShapeHash: added.ShapeHash, ArtNodePK: "art-node"}, &ink); err != nil {
+		t.Fatal(err)
+	}
+	if err := new(MinerRPC).GetShapeStatus(added.ShapeHash, &status); err != nil || status != (ShapeStatusReply{added.BlockHash, 3}) {
+		t.Errorf("deleted shape: got %v %v, want block %s at depth 3", status, err, added.BlockHash)
+	}
+	if err := new(MinerRPC).GetShapeStatus("nosuchshape", &status); err != (ErrorEnvelope{"InvalidShapeHash", "nosuchshape", InvalidShapeHashError("nosuchshape").Error()}) {
+		t.Errorf("unknown shape: got %v", err)
+	}
+}
+
+func deleteTestShape(t *testing.T, shapeHash string) {
+	var ink uint32
+	if err := new(MinerRPC).DeleteShape(DelShapeArgs{ShapeHash: shapeHash, ArtNodePK: "art-node"}, &ink); err != nil {
+		t.Fatalf("DeleteShape(%s): %v", shapeHash, err)
+	}
+}
+
+func canvasHashes(t *testing.T, blockHash string) []string {
+	var shapes []CanvasShape
+	if err := new(MinerRPC).GetCanvas(blockHash, &shapes); err != nil {
+		t.Fatalf("GetCanvas(%q): %v", blockHash, err)
+	}
+	hashes := make([]string, len(shapes))
+	for i, shape := range shapes {
+		hashes[i] = shape.ShapeHash
+	}
+	return hashes
+}
+
+// GetCanvas walks the chain up to the block, not the tip.
+func TestGetCanvasAtBlock(t *testing.T) {
+	newTestMiner(t, 1)
+	first := addTestShape(t, "M 0 0 L 0 5")
+	second := addTestShape(t, "M 10 0 L 10 5")
+	deleteTestShape(t, first.ShapeHash)
+	mineNoOpBlocks(globalPubKeyStr)
+
+	tests := []struct {
+		blockHash string
+		expected  []string
+	}{
+		{blockHashAt(blockChain, 0), []string{}},
+		{first.BlockHash, []string{first.ShapeHash}},
+		{second.BlockHash, []string{first.ShapeHash, second.ShapeHash}},
+		{blockHashAt(blockChain, 3), []string{second.ShapeHash}},
+		{"", []string{second.ShapeHash}},
+	}
+	for _, test := range tests {
+		if hashes := canvasHashes(t, test.blockHash); strings.Join(hashes, " ") != strings.Join(test.expected, " ") {
+			t.Errorf("canvas at %q: got %v, want %v", test.blockHash, hashes, test.expected)
+		}
+	}
+
+	var shapes []CanvasShape
+	new(MinerRPC).GetCanvas(second.BlockHash, &shapes)
+	want := CanvasShape{ShapeHash: first.ShapeHash, SvgString: `<path d="M 0 0 L 0 5" stroke="red" stroke-width="1" fill="transparent"/>`,
+		Path: "M 0 0 L 0 5", Owner: "art-node", Fill: "transparent", Stroke: "red", StrokeWidth: 1, InkCost: 6, BlockHash: first.BlockHash}
+	if len(shapes) != 2 || shapes[0] != want || shapes[1].BlockHash != second.BlockHash {
+		t.Errorf("canvas at the second op block: got %+v, want %+v first", shapes, want)
+	}
+	err := new(MinerRPC).GetCanvas("nosuchblock", &shapes)
+	if e, ok := err.(ErrorEnvelope); !ok || e.Code != "InvalidBlockHash" {
+		t.Errorf("canvas at unknown block: got %v", err)
+	}
+}
+
+// A deleted shape keeps the svg string it was drawn with.
+func TestGetSvgStringDeleted(t *testing.T) {
+	newTestMiner(t, 1)
+	added := addTestShape(t, "M 0 0 L 0 5")
+	deleteTestShape(t, added.ShapeHash)
+	mineNoOpBlocks(globalPubKeyStr)
+	var svg string
+	want := `<path d="M 0 0 L 0 5" stroke="red" stroke-width="1" fill="transparent"/>`
+	if err := new(MinerRPC).GetSvgString(added.ShapeHash, &svg); err != nil || svg != want {
+		t.Errorf("deleted shape: got %q %v, want %q", svg, err, want)
+	}
+	err := new(MinerRPC).GetSvgString("nosuchshape", &svg)
+	if e, ok := err.(ErrorEnvelope); !ok || e.Code != "InvalidShapeHash" {
+		t.Errorf("unknown shape: got %v", err)
+	}
+}
+
+func TestGetBlock(t *testing.T) {
+	newTestMiner(t, 1)
+	added := addTestShape(t, "M 0 0 L 0 5")
+	deleteTestShape(t, added.ShapeHash)
+	mineNoOpBlocks(globalPubKeyStr)
+	noOpHash, deleteHash, lastHash := blockHashAt(blockChain, 0), blockHashAt(blockChain, 2), blockHashAt(blockChain, 3)
+
+	tests := []struct {
+		blockHash string
+		expected  BlockInfo
+	}{
+		{settings.GenesisBlockHash, BlockInfo{BlockHash: settings.GenesisBlockHash, NoOpBlock: true}},
+		{noOpHash, BlockInfo{BlockHash: noOpHash, PrevHash: settings.GenesisBlockHash, Height: 1,
+			MinerKey: globalPubKeyStr, NoOpBlock: true, InkAwarded: 100}},
+		{added.BlockHash, BlockInfo{BlockHash: added.BlockHash, PrevHash: noOpHash, Height: 2, MinerKey: globalPubKeyStr,
+			Ops: []BlockOp{{"add", added.ShapeHash, "art-node", 6}}, InkAwarded: 50}},
+		{deleteHash, BlockInfo{BlockHash: deleteHash, PrevHash: added.BlockHash, Height: 3, MinerKey: globalPubKeyStr,
+			Ops: []BlockOp{{"delete", added.ShapeHash, "art-node", 0}}, InkAwarded: 50}},
+		{lastHash, BlockInfo{BlockHash: lastHash, PrevHash: deleteHash, Height: 4, MinerKey: globalPubKeyStr,
+			NoOpBlock: true, InkAwarded: 100}},
+	}
+	for _, test := range tests {
+		var block BlockInfo
+		if err := new(MinerRPC).GetBlock(test.blockHash, &block); err != nil {
+			t.Errorf("block %s: %v", test.blockHash, err)
+			continue
+		}
+		block.Nonce = 0
+		if !reflect.DeepEqual(block, test.expected) {
+			t.Errorf("block %s: got %+v, want %+v", test.blockHash, block, test.expected)
+		}
+	}
+	var block BlockInfo
+	err := new(MinerRPC).GetBlock("nosuchblock", &block)
+	if e, ok := err.(ErrorEnvelope); !ok || e.Code != "InvalidBlockHash" {
+		t.Errorf("unknown block: got %v", err)
+	}
+}
+
+// The element of an op must draw the path that was charged for.
+func TestValidShapeElement(t *testing.T) {
+	newTestMiner(t, 2)
+	addTestShape(t, "M 0 0 L 0 5")
+	shapes := []AddShapeStruct{
+		{SType: RECT, Params: SvgHelper.ShapeParams{X: 10, Y: 10, Width: 4, Height: 4}},
+		{SType: ELLIPSE, Params: SvgHelper.ShapeParams{Cx: 30, Cy: 30, Rx: 3, Ry: 2}},
+		{SType: POLYGON, Params: SvgHelper.ShapeParams{Points: []SvgHelper.Vertex{{X: 50, Y: 50}, {X: 55, Y: 50}, {X: 55, Y: 55}}}},
+	}
+	for _, shape := range shapes {
+		shape.Fill, shape.Stroke, shape.ArtNodePK = "transparent", "red", "art-node"
+		var reply AddShapeReply
+		if err := new(MinerRPC).AddShape(shape, &reply); err != nil {
+			t.Fatalf("AddShape(%+v): %v", shape, err)
+		}
+		var moved MoveShapeReply
+		if err := new(MinerRPC).MoveShape(MoveShapeArgs{ShapeHash: reply.ShapeHash, ArtNodePK: "art-node", Dx: 3, Dy: 1}, &moved); err != nil {
+			t.Fatalf("MoveShape(%+v): %v", shape, err)
+		}
+	}
+	ops := blockChain[len(blockChain)-1].Ops
+	for _, op := range ops {
+		if !validShapeElement(op) {
+			t.Errorf("op %q drawn as %q: rejected", op.ShapeCommand, op.AppShape)
+		}
+	}
+
+	forged := []Operation{
+		// a long line drawn for the ink of a short one
+		{AppShape: `<path d="M 0 0 L 99 99" stroke="red" stroke-width="1" fill="transparent"/>`, ShapeCommand: "M 0 0 L 1 1"},
+		{AppShape: `<rect x="10" y="10" width="40" height="40" stroke="red" stroke-width="1" fill="transparent"/>`,
+			ShapeCommand: "M 10 10 h 4 v 4 h -4 z"},
+		{AppShape: `<rect x="10" y="10" width="4" height="4" onload="x" stroke="red" stroke-width="1" fill="transparent"/>`,
+			ShapeCommand: "M 10 10 h 4 v 4 h -4 z"},
+		{AppShape: `<circle cx="1" cy="1" r="1" stroke="red" stroke-width="1" fill="transparent"/>`, ShapeCommand: "M 0 0 L 1 1"},
+	}
+	for _, op := range forged {
+		op.ShapeFill, op.ShapeStroke, op.ShapeStrokeWidth = "transparent", "red", 1
+		if validShapeElement(op) {
+			t.Errorf("op %q drawn as %q: accepted", op.ShapeCommand, op.AppShape)
+		}
+	}
+}
+
+func TestMoveShapeErrors(t *testing.T) {
+	newTestMiner(t, 1)
+	deleted := addTestShape(t, "M 0 0 L 0 5")
+	live := addTestShape(t, "M 10 0 L 10 5")
+	deleteTestShape(t, deleted.ShapeHash)
+	tests := []struct {
+		args     MoveShapeArgs
+		expected error
+	}{
+		{MoveShapeArgs{ShapeHash: deleted.ShapeHash, ArtNodePK: "art-node", Dx: 1},
+			ErrorEnvelope{"InvalidShapeHash", deleted.ShapeHash, InvalidShapeHashError(deleted.ShapeHash).Error()}},
+		{MoveShapeArgs{ShapeHash: "nosuchshape", ArtNodePK: "art-node", Dx: 1},
+			ErrorEnvelope{"InvalidShapeHash", "nosuchshape", InvalidShapeHashError("nosuchshape").Error()}},
+		{MoveShapeArgs{ShapeHash: live.ShapeHash, ArtNodePK: "other-art-node", Dx: 1},
+			ErrorEnvelope{"ShapeOwner", live.ShapeHash, ShapeOwnerError(live.ShapeHash).Error()}},
+	}
+	for _, test := range tests {
+		var reply MoveShapeReply
+		if err := new(MinerRPC).MoveShape(test.args, &reply); err != test.expected {
+			t.Errorf("MoveShape(%+v): got %v, want %v", test.args, err, test.expected)
+		}
+	}
+}
+
+func waitingRequestCount() int {
+	waitingRequests.Lock()
+	defer waitingRequests.Unlock()
+	return len(waitingRequests.ids)
+}
+
+// A cancel stops the request waiting for confirmations, and cancels of
+// requests that are unknown or done leave nothing behind.
+func TestCancelRequest(t *testing.T) {
+	newTestMiner(t, 1)
+	var ok bool
+	if err := new(MinerRPC).CancelRequest("unknown", &ok); err != nil || ok {
+		t.Errorf("unknown request: got %v %v, want false", ok, err)
+	}
+	if n := waitingRequestCount(); n != 0 {
+		t.Errorf("unknown request: %d requests left waiting", n)
+	}
+
+	done := make(chan error)
+	go func() {
+		var reply AddShapeReply
+		done <- new(MinerRPC).AddShape(AddShapeStruct{ValidateNum: 5, SType: PATH, ShapeSvgString: "M 0 0 L 0 5",
+			Fill: "transparent", Stroke: "red", ArtNodePK: "art-node", RequestID: "request"}, &reply)
+	}()
+	for waitingRequestCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := new(MinerRPC).CancelRequest("request", &ok); err != nil || !ok {
+		t.Errorf("waiting request: got %v %v, want true", ok, err)
+	}
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "cancelled") {
+			t.Errorf("got %v, want the request cancelled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request still waiting for confirmations after the cancel")
+	}
+	if err := new(MinerRPC).CancelRequest("request", &ok); err != nil || ok {
+		t.Errorf("done request: got %v %v, want false", ok, err)
+	}
+	if n := waitingRequestCount(); n != 0 {
+		t.Errorf("done request: %d requests left waiting", n)
 	}
 }
@@ -0,0 +1,106 @@
+// Package render draws a snapshot of the canvas as a standalone SVG
+// document or as a PNG image. The PNG is rasterised with the same pixel
+// model SvgHelper uses for ink and overlap, so every pixel a shape paid
+// ink for is painted and no other.
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"../SvgHelper"
+)
+
+// A shape of the canvas snapshot, drawn in the order given.
+type Shape struct {
+	SvgString   string // svg element the shape is drawn with
+	Path        string // svg path with the geometry of the shape
+	Fill        string // fill colour or transparent
+	Stroke      string // stroke colour or transparent
+	StrokeWidth int
+}
+
+// colour of the canvas where no shape is drawn
+var background = color.RGBA{255, 255, 255, 255}
+
+// Returns the shapes as a standalone svg document the size of the canvas.
+func SVG(shapes []Shape, canvas SvgHelper.CanvasSettings) string {
+	width := strconv.Itoa(int(canvas.CanvasXMax))
+	height := strconv.Itoa(int(canvas.CanvasYMax))
+	var doc strings.Builder
+	doc.WriteString("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	doc.WriteString("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"" + width +
+		"\" height=\"" + height + "\" viewBox=\"0 0 " + width + " " + height + "\">\n")
+	for _, shape := range shapes {
+		doc.WriteString("  " + shape.SvgString + "\n")
+	}
+	doc.WriteString("</svg>\n")
+	return doc.String()
+}
+
+// Rasterises the shapes onto a white image with one pixel per canvas
+// coordinate, CanvasXMax by CanvasYMax pixels like the svg document. The
+// interior of a shape is painted with its fill and the outline with its
+// stroke, or with its fill when the stroke is transparent.
+// Can return the following errors:
+// - InvalidColourError: if a fill or stroke is not a colour
+// - InvalidShapeSvgStringError, OutofBoundError: if a path is not a valid shape
+func PNG(shapes []Shape, canvas SvgHelper.CanvasSettings) (*image.RGBA, error) {
+	img := image.NewRGBA(image.Rect(0, 0, int(canvas.CanvasXMax), int(canvas.CanvasYMax)))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+	for _, shape := range shapes {
+		fill, err := SvgHelper.ParseColour(shape.Fill)
+		if err != nil {
+			return nil, err
+		}
+		stroke, err := SvgHelper.ParseColour(shape.Stroke)
+		if err != nil {
+			return nil, err
+		}
+		if stroke.A == 0 {
+			stroke = fill
+		}
+		outline, interior, err := SvgHelper.ShapePixels(shape.Path, shape.Fill, shape.StrokeWidth, canvas)
+		if err != nil {
+			return nil, err
+		}
+		paint(img, interior, fill)
+		paint(img, outline, stroke)
+	}
+	return img, nil
+}
+
+// Writes the svg document of the shapes to the file.
+func WriteSVG(filename string, shapes []Shape, canvas SvgHelper.CanvasSettings) error {
+	return ioutil.WriteFile(filename, []byte(SVG(shapes, canvas)), 0644)
+}
+
+// Writes the png image of the shapes to the file.
+func WritePNG(filename string, shapes []Shape, canvas SvgHelper.CanvasSettings) error {
+	img, err := PNG(shapes, canvas)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// Paints the pixels with the colour, nothing is painted for transparent.
+func paint(img *image.RGBA, pixels []SvgHelper.Vertex, c color.RGBA) {
+	if c.A == 0 {
+		return
+	}
+	for _, p := range pixels {
+		img.SetRGBA(p.X, p.Y, c)
+	}
+}
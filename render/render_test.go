@@ -0,0 +1,47 @@
+package render
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+
+	"../SvgHelper"
+)
+
+var testCanvas = SvgHelper.CanvasSettings{CanvasXMax: 20, CanvasYMax: 10}
+
+func TestSVG(t *testing.T) {
+	svg := SVG([]Shape{{SvgString: "<path d=\"M 0 0 h 4\" stroke=\"red\" fill=\"transparent\"/>"}}, testCanvas)
+	if !strings.Contains(svg, "width=\"20\" height=\"10\"") || !strings.Contains(svg, "<path d=\"M 0 0 h 4\"") {
+		t.Error("Expected a 20x10 document with the shape, got: ", svg)
+	}
+}
+
+func TestPNG(t *testing.T) {
+	shapes := []Shape{
+		{Path: "M 0 0 l 4 0 v 4 h -4 z", Fill: "blue", Stroke: "red", StrokeWidth: 1},
+		{Path: "M 10 0 h 4", Fill: "transparent", Stroke: "#00ff00", StrokeWidth: 1},
+	}
+	img, err := PNG(shapes, testCanvas)
+	if err != nil {
+		t.Fatal("Expected no error, got: ", err)
+	}
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 10 {
+		t.Error("Expected a 20x10 image, got: ", img.Bounds())
+	}
+	pixels := map[[2]int]color.RGBA{
+		{0, 0}:  {255, 0, 0, 255},
+		{2, 2}:  {0, 0, 255, 255},
+		{12, 0}: {0, 255, 0, 255},
+		{12, 1}: {255, 255, 255, 255},
+		{19, 9}: {255, 255, 255, 255},
+	}
+	for p, expected := range pixels {
+		if got := img.RGBAAt(p[0], p[1]); got != expected {
+			t.Error("Expected ", expected, " at ", p, " got: ", got)
+		}
+	}
+	if _, err := PNG([]Shape{{Path: "M 0 0 h 4", Fill: "transparent", Stroke: "\"/><script>"}}, testCanvas); err == nil {
+		t.Error("Expected InvalidColourError")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+)
+
+// Picks the peers GetNodes returns to a miner. Strategies are called with
+// allMiners locked, so they may keep state between calls.
+type peerStrategy interface {
+	// Returns at most n addresses of miners other than the miner with key
+	// k, out of miners.
+	peers(k string, miners map[string]*Miner, n int) []net.Addr
+}
+
+// Returns the strategy named by "peer-selection" in the config:
+//   - "random" (or ""): n miners picked uniformly at random
+//   - "least-handed-out": the n miners GetNodes returned least often so far
+//   - "ring": the n nearest miners on a ring of all miners sorted by address
+//   - "small-world": ring neighbours, each but the next one on the ring
+//     replaced by a random miner with probability rewire
+//   - "exclude-known": random miners not returned to this miner before
+func newPeerStrategy(name string, rewire float64, r *rand.Rand) (peerStrategy, error) {
+	switch name {
+	case "", "random":
+		return randomPeers{r}, nil
+	case "least-handed-out":
+		return &leastHandedOutPeers{r, make(map[string]int)}, nil
+	case "ring":
+		return ringPeers{}, nil
+	case "small-world":
+		if rewire < 0 || rewire > 1 {
+			return nil, fmt.Errorf("small-world-rewire must be between 0 and 1, not %v", rewire)
+		}
+		return smallWorldPeers{r, rewire}, nil
+	case "exclude-known":
+		return &excludeKnownPeers{r, make(map[string]map[string]bool)}, nil
+	}
+	return nil, fmt.Errorf("unknown peer-selection %q", name)
+}
+
+type randomPeers struct {
+	r *rand.Rand
+}
+
+func (s randomPeers) peers(k string, miners map[string]*Miner, n int) []net.Addr {
+	others := otherAddresses(k, miners)
+	s.r.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	return others[:minInt(n, len(others))]
+}
+
+type leastHandedOutPeers struct {
+	r         *rand.Rand
+	handedOut map[string]int // times each address was returned
+}
+
+func (s *leastHandedOutPeers) peers(k string, miners map[string]*Miner, n int) []net.Addr {
+	others := otherAddresses(k, miners)
+	// shuffle first so ties are broken at random
+	s.r.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	sort.SliceStable(others, func(i, j int) bool {
+		return s.handedOut[others[i].String()] < s.handedOut[others[j].String()]
+	})
+	others = others[:minInt(n, len(others))]
+	for _, addr := range others {
+		s.handedOut[addr.String()]++
+	}
+	return others
+}
+
+type ringPeers struct{}
+
+func (s ringPeers) peers(k string, miners map[string]*Miner, n int) []net.Addr {
+	ring, self := ringOf(k, miners)
+	addrs := make([]net.Addr, 0, minInt(n, len(ring)-1))
+	for _, i := range ringNeighbours(self, len(ring), n) {
+		addrs = append(addrs, ring[i])
+	}
+	return addrs
+}
+
+type smallWorldPeers struct {
+	r      *rand.Rand
+	rewire float64
+}
+
+func (s smallWorldPeers) peers(k string, miners map[string]*Miner, n int) []net.Addr {
+	ring, self := ringOf(k, miners)
+	neighbours := ringNeighbours(self, len(ring), n)
+	chosen := map[int]bool{self: true}
+	for _, i := range neighbours {
+		chosen[i] = true
+	}
+	// the next miner on the ring is kept so the ring keeps every miner
+	// connected; the others are rewired to random miners
+	for j := 1; j < len(neighbours); j++ {
+		if len(chosen) == len(ring) || s.r.Float64() >= s.rewire {
+			continue
+		}
+		i := s.r.Intn(len(ring))
+		for chosen[i] {
+			i = (i + 1) % len(ring)
+		}
+		delete(chosen, neighbours[j])
+		chosen[i] = true
+		neighbours[j] = i
+	}
+	addrs := make([]net.Addr, len(neighbours))
+	for j, i := range neighbours {
+		addrs[j] = ring[i]
+	}
+	return addrs
+}
+
+type excludeKnownPeers struct {
+	r     *rand.Rand
+	given map[string]map[string]bool // addresses returned to each miner
+}
+
+func (s *excludeKnownPeers) peers(k string, miners map[string]*Miner, n int) []net.Addr {
+	others := otherAddresses(k, miners)
+	s.r.Shuffle(len(others), func(i, j int) { others[i], others[j] = others[j], others[i] })
+	given := s.given[k]
+	if given == nil {
+		given = make(map[string]bool)
+		s.given[k] = given
+	}
+	fresh := make([]net.Addr, 0, len(others))
+	for _, addr := range others {
+		if !given[addr.String()] {
+			fresh = append(fresh, addr)
+		}
+	}
+	if len(fresh) == 0 {
+		// the miner was given every miner already, start over
+		for addr := range given {
+			delete(given, addr)
+		}
+		fresh = others
+	}
+	fresh = fresh[:minInt(n, len(fresh))]
+	for _, addr := range fresh {
+		given[addr.String()] = true
+	}
+	return fresh
+}
+
+// Addresses of the miners other than the miner with key k, sorted.
+func otherAddresses(k string, miners map[string]*Miner) []net.Addr {
+	addrs := make([]net.Addr, 0, len(miners))
+	for key, miner := range miners {
+		if key != k {
+			addrs = append(addrs, miner.Address)
+		}
+	}
+	sort.Sort(Addresses(addrs))
+	return addrs
+}
+
+// Addresses of all miners sorted, and the index of the miner with key k.
+func ringOf(k string, miners map[string]*Miner) (ring []net.Addr, self int) {
+	ring = otherAddresses("", miners)
+	self = sort.Search(len(ring), func(i int) bool { return ring[i].String() >= miners[k].Address.String() })
+	return ring, self
+}
+
+// Indexes of the up to n nearest miners to self on a ring of size miners,
+// alternating after and before it: +1, -1, +2, -2, ...
+func ringNeighbours(self int, size int, n int) []int {
+	var neighbours []int
+	seen := map[int]bool{self: true}
+	for d := 1; len(neighbours) < n && len(seen) < size; d++ {
+		for _, i := range []int{(self + d) % size, ((self-d)%size + size) % size} {
+			if len(neighbours) < n && !seen[i] {
+				seen[i] = true
+				neighbours = append(neighbours, i)
+			}
+		}
+	}
+	return neighbours
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+)
+
+var strategies = []string{"random", "least-handed-out", "ring", "small-world", "exclude-known"}
+
+// Miners keyed "miner-i" at addresses 10.0.0.1:10000+i.
+func testMiners(count int) map[string]*Miner {
+	miners := make(map[string]*Miner)
+	for i := 0; i < count; i++ {
+		addr, _ := net.ResolveTCPAddr("tcp", fmt.Sprintf("10.0.0.1:%d", 10000+i))
+		miners[fmt.Sprintf("miner-%d", i)] = &Miner{Address: addr}
+	}
+	return miners
+}
+
+// Returns whether the undirected graph of edges reaches every miner.
+func connected(miners map[string]*Miner, edges map[string][]string) bool {
+	byAddr := make(map[string]string)
+	var start string
+	for k, miner := range miners {
+		byAddr[miner.Address.String()] = k
+		start = k
+	}
+	adjacent := make(map[string][]string)
+	for k, addrs := range edges {
+		for _, addr := range addrs {
+			peer := byAddr[addr]
+			adjacent[k] = append(adjacent[k], peer)
+			adjacent[peer] = append(adjacent[peer], k)
+		}
+	}
+	seen := map[string]bool{start: true}
+	queue := []string{start}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		for _, peer := range adjacent[k] {
+			if !seen[peer] {
+				seen[peer] = true
+				queue = append(queue, peer)
+			}
+		}
+	}
+	return len(seen) == len(miners)
+}
+
+func checkPeers(t *testing.T, name string, k string, miners map[string]*Miner, addrs []net.Addr, n int) []string {
+	want := n
+	if len(miners)-1 < want {
+		want = len(miners) - 1
+	}
+	if len(addrs) != want {
+		t.Errorf("%s: %s got %d peers, want %d", name, k, len(addrs), want)
+	}
+	seen := make(map[string]bool)
+	peers := make([]string, len(addrs))
+	for i, addr := range addrs {
+		if addr.String() == miners[k].Address.String() {
+			t.Errorf("%s: %s was given its own address", name, k)
+		}
+		if seen[addr.String()] {
+			t.Errorf("%s: %s was given %s twice", name, k, addr)
+		}
+		seen[addr.String()] = true
+		peers[i] = addr.String()
+	}
+	return peers
+}
+
+// Every miner asks for peers once all have registered.
+func TestPeerGraphConnected(t *testing.T) {
+	for _, name := range strategies {
+		for _, n := range []int{1, 2, 3, 5} {
+			for seed := int64(0); seed < 20; seed++ {
+				strategy, err := newPeerStrategy(name, 0.3, rand.New(rand.NewSource(seed)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				miners := testMiners(40)
+				edges := make(map[string][]string)
+				for k := range miners {
+					edges[k] = checkPeers(t, name, k, miners, strategy.peers(k, miners, n), n)
+				}
+				// a single random peer each may leave miners paired off
+				if n > 1 || name == "ring" || name == "small-world" {
+					if !connected(miners, edges) {
+						t.Errorf("%s: %d peers each, seed %d: graph not connected", name, n, seed)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Miners register one after another and ask for peers as they join, and
+// once more after everyone has joined.
+func TestPeerGraphConnectedAsMinersJoin(t *testing.T) {
+	for _, name := range strategies {
+		strategy, err := newPeerStrategy(name, 0.3, rand.New(rand.NewSource(1)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		all := testMiners(30)
+		miners := make(map[string]*Miner)
+		edges := make(map[string][]string)
+		for i := 0; i < len(all); i++ {
+			k := fmt.Sprintf("miner-%d", i)
+			miners[k] = all[k]
+			edges[k] = checkPeers(t, name, k, miners, strategy.peers(k, miners, 3), 3)
+			if !connected(miners, edges) {
+				t.Errorf("%s: graph not connected after %d miners joined", name, i+1)
+			}
+		}
+		for k := range miners {
+			edges[k] = append(edges[k], checkPeers(t, name, k, miners, strategy.peers(k, miners, 3), 3)...)
+		}
+		if !connected(miners, edges) {
+			t.Errorf("%s: graph not connected after asking again", name)
+		}
+	}
+}
+
+func TestLeastHandedOutSpreadsPeers(t *testing.T) {
+	strategy, _ := newPeerStrategy("least-handed-out", 0, rand.New(rand.NewSource(1)))
+	miners := testMiners(20)
+	counts := make(map[string]int)
+	for k := range miners {
+		for _, addr := range strategy.peers(k, miners, 3) {
+			counts[addr.String()]++
+		}
+	}
+	for addr, count := range counts {
+		if count < 2 || count > 4 {
+			t.Errorf("%s handed out %d times, want 3 give or take 1", addr, count)
+		}
+	}
+}
+
+func TestRingPeers(t *testing.T) {
+	strategy, _ := newPeerStrategy("ring", 0, nil)
+	miners := testMiners(10)
+	got := strategy.peers("miner-0", miners, 4)
+	want := []string{"10.0.0.1:10001", "10.0.0.1:10009", "10.0.0.1:10002", "10.0.0.1:10008"}
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestExcludeKnownPeers(t *testing.T) {
+	strategy, _ := newPeerStrategy("exclude-known", 0, rand.New(rand.NewSource(1)))
+	miners := testMiners(7)
+	given := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		for _, addr := range strategy.peers("miner-0", miners, 2) {
+			if given[addr.String()] {
+				t.Errorf("%s given again before all miners were", addr)
+			}
+			given[addr.String()] = true
+		}
+	}
+	if len(given) != 6 {
+		t.Errorf("got %d distinct peers, want 6", len(given))
+	}
+	if got := strategy.peers("miner-0", miners, 2); len(got) != 2 {
+		t.Errorf("got %d peers after all were given, want 2", len(got))
+	}
+}
+
+func TestUnknownPeerStrategy(t *testing.T) {
+	if _, err := newPeerStrategy("nearest", 0, nil); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+	if _, err := newPeerStrategy("small-world", 1.5, nil); err == nil {
+		t.Error("rewire probability 1.5 accepted")
+	}
+}
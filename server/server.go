@@ -3,9 +3,15 @@
 Implements an example server for the BlockArt project, to be used in
 project 1 of UBC CS 416 2017W2.
 
-This server takes in settings from an input json files and implements
-a simple strategy for GetNodes: return a fixed number of random miners
-("num-miner-to-return" in the json config file).
+This server takes in settings from an input json files and returns a fixed
+number of miners from GetNodes ("num-miner-to-return" in the json config
+file). "peer-selection" picks the strategy that chooses them: "random"
+(the default), "least-handed-out", "ring", "small-world" or
+"exclude-known", see newPeerStrategy.
+
+Registrations are saved to "registrations-file" in the json config file,
+if it is set, and loaded from it when the server starts. Miners that were
+registered before a restart keep heartbeating without registering again.
 
 Usage:
 
@@ -20,13 +26,17 @@ package main
 import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
+	crand "crypto/rand"
+	"crypto/sha256"
 	"encoding/gob"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"math/big"
 	"math/rand"
 	"net"
 	"net/rpc"
@@ -51,6 +61,12 @@ func (e AddressAlreadyRegisteredError) Error() string {
 	return fmt.Sprintf("BlockArt server: address already registered [%s]", string(e))
 }
 
+type InvalidSignatureError string
+
+func (e InvalidSignatureError) Error() string {
+	return fmt.Sprintf("BlockArt server: invalid signature [%s]", string(e))
+}
+
 // Settings for a canvas in BlockArt.
 type CanvasSettings struct {
 	// Canvas dimensions
@@ -107,12 +123,27 @@ type RServer int
 type Miner struct {
 	Address         net.Addr
 	RecentHeartbeat int64
+	// Nonce handed out by DeregisterNonce, nil if there is none. Deregister
+	// needs it signed with the key of the miner, and it is used only once.
+	Nonce []byte
 }
 
 type Config struct {
 	MinerSettings    MinerNetSettings `json:"miner-settings"`
 	RpcIpPort        string           `json:"rpc-ip-port"`
 	NumMinerToReturn uint8            `json:"num-miner-to-return"`
+	// File registrations are saved to, none are saved if empty.
+	RegistrationsFile string `json:"registrations-file"`
+	// Strategy GetNodes picks peers with, see newPeerStrategy.
+	PeerSelection string `json:"peer-selection"`
+	// Probability a small-world neighbour is replaced by a random miner.
+	SmallWorldRewire float64 `json:"small-world-rewire"`
+}
+
+// A registration as it is saved in the registrations file.
+type SavedMiner struct {
+	Key     string // hex of the key as pubKeyToString gives it
+	Address string
 }
 
 type AllMiners struct {
@@ -127,6 +158,8 @@ var (
 	outLog          *log.Logger = log.New(os.Stderr, "[serv] ", log.Lshortfile|log.LUTC|log.Lmicroseconds)
 	// Miners in the system.
 	allMiners AllMiners = AllMiners{all: make(map[string]*Miner)}
+	// Picks the miners GetNodes returns, guarded by allMiners.
+	peerSelection peerStrategy
 )
 
 func readConfigOrDie(path string) {
@@ -154,8 +187,13 @@ func main() {
 	}
 
 	readConfigOrDie(*path)
+	handleErrorFatal("load registrations", loadRegistrations())
 
 	rand.Seed(time.Now().UnixNano())
+	strategy, err := newPeerStrategy(config.PeerSelection, config.SmallWorldRewire,
+		rand.New(rand.NewSource(time.Now().UnixNano())))
+	handleErrorFatal("peer-selection", err)
+	peerSelection = strategy
 
 	rserver := new(RServer)
 
@@ -178,13 +216,27 @@ type MinerInfo struct {
 	Key     ecdsa.PublicKey
 }
 
-// Function to delete dead miners (no recent heartbeat)
-func monitor(k string, heartBeatInterval time.Duration) {
+// Proof that the miner deregistering holds the private key of Key: the
+// ecdsa signature of the sha256 of the nonce DeregisterNonce returned.
+type DeregisterArgs struct {
+	Key ecdsa.PublicKey
+	R   *big.Int
+	S   *big.Int
+}
+
+// Function to delete dead miners (no recent heartbeat). Stops when the
+// registration of miner is removed or replaced.
+func monitor(k string, miner *Miner, heartBeatInterval time.Duration) {
 	for {
 		allMiners.Lock()
+		if allMiners.all[k] != miner {
+			allMiners.Unlock()
+			return
+		}
 		if time.Now().UnixNano()-allMiners.all[k].RecentHeartbeat > int64(heartBeatInterval) {
 			outLog.Printf("%s timed out\n", allMiners.all[k].Address.String())
 			delete(allMiners.all, k)
+			saveRegistrations()
 			allMiners.Unlock()
 			return
 		}
@@ -203,30 +255,34 @@ func pubKeyToString(key ecdsa.PublicKey) string {
 // public-key for this miner. Returns error, or if error is not set,
 // then setting for this canvas instance.
 //
+// A miner that is already registered, for example one that restarted
+// before its registration timed out, registers again with the same key,
+// from the same address or a new one.
+//
 // Returns:
-// - AddressAlreadyRegisteredError if the server has already registered this address.
-// - KeyAlreadyRegisteredError if the server already has a registration record for publicKey.
+// - AddressAlreadyRegisteredError if the server has registered this address for another key.
 func (s *RServer) Register(m MinerInfo, r *MinerNetSettings) error {
 	allMiners.Lock()
 	defer allMiners.Unlock()
 
 	k := pubKeyToString(m.Key)
-	if miner, exists := allMiners.all[k]; exists {
-		return KeyAlreadyRegisteredError(miner.Address.String())
-	}
-
-	for _, miner := range allMiners.all {
-		if miner.Address.Network() == m.Address.Network() && miner.Address.String() == m.Address.String() {
+	for key, miner := range allMiners.all {
+		if key != k && miner.Address.Network() == m.Address.Network() && miner.Address.String() == m.Address.String() {
 			return AddressAlreadyRegisteredError(m.Address.String())
 		}
 	}
 
-	allMiners.all[k] = &Miner{
-		m.Address,
-		time.Now().UnixNano(),
+	if miner, exists := allMiners.all[k]; exists {
+		outLog.Printf("%s registers again from %s\n", miner.Address.String(), m.Address.String())
+	}
+	miner := &Miner{
+		Address:         m.Address,
+		RecentHeartbeat: time.Now().UnixNano(),
 	}
+	allMiners.all[k] = miner
+	saveRegistrations()
 
-	go monitor(k, time.Duration(config.MinerSettings.HeartBeat)*time.Millisecond)
+	go monitor(k, miner, time.Duration(config.MinerSettings.HeartBeat)*time.Millisecond)
 
 	*r = config.MinerSettings
 
@@ -235,6 +291,131 @@ func (s *RServer) Register(m MinerInfo, r *MinerNetSettings) error {
 	return nil
 }
 
+// Returns a new nonce for the miner with this publicKey to sign and pass
+// to Deregister. It replaces any nonce handed out before.
+//
+// Returns:
+// - UnknownKeyError if the server does not know a miner with this publicKey.
+func (s *RServer) DeregisterNonce(key ecdsa.PublicKey, nonce *[]byte) error {
+	allMiners.Lock()
+	defer allMiners.Unlock()
+
+	miner, ok := allMiners.all[pubKeyToString(key)]
+	if !ok {
+		return unknownKeyError
+	}
+	miner.Nonce = make([]byte, 32)
+	if _, err := crand.Read(miner.Nonce); err != nil {
+		miner.Nonce = nil
+		return err
+	}
+	*nonce = miner.Nonce
+	return nil
+}
+
+// Removes the registration of a miner, so the server stops returning its
+// address to other miners. Miners call it when they shut down, with the
+// nonce from DeregisterNonce signed by their key; the nonce can not be used
+// again either way.
+//
+// Returns:
+// - UnknownKeyError if the server does not know a miner with this publicKey.
+// - InvalidSignatureError if there is no nonce or it is not signed by the key.
+func (s *RServer) Deregister(args DeregisterArgs, _ignored *bool) error {
+	allMiners.Lock()
+	defer allMiners.Unlock()
+
+	k := pubKeyToString(args.Key)
+	miner, ok := allMiners.all[k]
+	if !ok {
+		return unknownKeyError
+	}
+	nonce := miner.Nonce
+	miner.Nonce = nil
+	if nonce == nil || args.R == nil || args.S == nil {
+		return InvalidSignatureError(miner.Address.String())
+	}
+	hash := sha256.Sum256(nonce)
+	if !ecdsa.Verify(&args.Key, hash[:], args.R, args.S) {
+		return InvalidSignatureError(miner.Address.String())
+	}
+	delete(allMiners.all, k)
+	saveRegistrations()
+
+	outLog.Printf("Got Deregister from %s\n", miner.Address.String())
+
+	return nil
+}
+
+// Saves the registrations to the registrations file, if there is one.
+// allMiners must be locked.
+func saveRegistrations() {
+	if config.RegistrationsFile == "" {
+		return
+	}
+	saved := make([]SavedMiner, 0, len(allMiners.all))
+	for k, miner := range allMiners.all {
+		saved = append(saved, SavedMiner{hex.EncodeToString([]byte(k)), miner.Address.String()})
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Key < saved[j].Key })
+	buffer, err := json.MarshalIndent(saved, "", "    ")
+	if err != nil {
+		errLog.Printf("save registrations, err = %s\n", err.Error())
+		return
+	}
+	// write a new file and rename it, so a crash never leaves half a file
+	tmp := config.RegistrationsFile + ".tmp"
+	if err = ioutil.WriteFile(tmp, buffer, 0644); err == nil {
+		err = os.Rename(tmp, config.RegistrationsFile)
+	}
+	if err != nil {
+		errLog.Printf("save registrations, err = %s\n", err.Error())
+	}
+}
+
+// Loads the registrations saved before the server restarted. Each miner
+// gets a full heartbeat interval to send its next heartbeat. A missing file
+// is no registrations; a file that can not be read or parsed is an error,
+// and nothing is loaded from it.
+func loadRegistrations() error {
+	if config.RegistrationsFile == "" {
+		return nil
+	}
+	buffer, err := ioutil.ReadFile(config.RegistrationsFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var saved []SavedMiner
+	if err = json.Unmarshal(buffer, &saved); err != nil {
+		return err
+	}
+	miners := make(map[string]*Miner, len(saved))
+	for _, m := range saved {
+		key, err := hex.DecodeString(m.Key)
+		if err != nil {
+			return err
+		}
+		addr, err := net.ResolveTCPAddr("tcp", m.Address)
+		if err != nil {
+			return err
+		}
+		miners[string(key)] = &Miner{Address: addr, RecentHeartbeat: time.Now().UnixNano()}
+	}
+
+	allMiners.Lock()
+	defer allMiners.Unlock()
+	for k, miner := range miners {
+		allMiners.all[k] = miner
+		go monitor(k, miner, time.Duration(config.MinerSettings.HeartBeat)*time.Millisecond)
+	}
+	outLog.Printf("Loaded %d registrations from %s\n", len(saved), config.RegistrationsFile)
+	return nil
+}
+
 type Addresses []net.Addr
 
 func (a Addresses) Len() int           { return len(a) }
@@ -250,8 +431,9 @@ func (s *RServer) GetNodes(key ecdsa.PublicKey, addrSet *[]net.Addr) error {
 	// TODO: validate miner's GetNodes protocol? (could monitor state
 	// of network graph/connectivity and validate protocol FSM)
 
-	allMiners.RLock()
-	defer allMiners.RUnlock()
+	// strategies keep state between calls, so no other call may run
+	allMiners.Lock()
+	defer allMiners.Unlock()
 
 	k := pubKeyToString(key)
 
@@ -259,29 +441,7 @@ func (s *RServer) GetNodes(key ecdsa.PublicKey, addrSet *[]net.Addr) error {
 		return unknownKeyError
 	}
 
-	minerAddresses := make([]net.Addr, 0, len(allMiners.all)-1)
-
-	for pubKey, miner := range allMiners.all {
-		if pubKey == k {
-			continue
-		}
-		minerAddresses = append(minerAddresses, miner.Address)
-	}
-
-	sort.Sort(Addresses(minerAddresses))
-
-	deterministicRandomNumber := key.X.Int64() % 32
-	r := rand.New(rand.NewSource(deterministicRandomNumber))
-	for n := len(minerAddresses); n > 0; n-- {
-		randIndex := r.Intn(n)
-		minerAddresses[n-1], minerAddresses[randIndex] = minerAddresses[randIndex], minerAddresses[n-1]
-	}
-
-	n := len(minerAddresses)
-	if int(config.NumMinerToReturn) < n {
-		n = int(config.NumMinerToReturn)
-	}
-	*addrSet = minerAddresses[:n]
+	*addrSet = peerSelection.peers(k, allMiners.all, int(config.NumMinerToReturn))
 
 	return nil
 }
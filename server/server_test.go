@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"path/filepath"
+	"testing"
+)
+
+// Starts the server over with no miners, saving registrations in a new
+// temporary directory. The heartbeat is long enough that no miner times out
+// during a test.
+func resetServer(t *testing.T) {
+	config = Config{RegistrationsFile: filepath.Join(t.TempDir(), "registrations.json")}
+	config.MinerSettings.HeartBeat = 60000
+	allMiners.Lock()
+	allMiners.all = make(map[string]*Miner)
+	allMiners.Unlock()
+}
+
+func testKey(t *testing.T) ecdsa.PublicKey {
+	return testPrivKey(t).PublicKey
+}
+
+func testPrivKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// Signs the nonce for Deregister as the miner does.
+func signNonce(t *testing.T, key *ecdsa.PrivateKey, nonce []byte) DeregisterArgs {
+	hash := sha256.Sum256(nonce)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return DeregisterArgs{key.PublicKey, r, s}
+}
+
+// Deregisters the miner with key, proving it holds the key.
+func deregister(t *testing.T, s *RServer, key *ecdsa.PrivateKey) error {
+	var nonce []byte
+	if err := s.DeregisterNonce(key.PublicKey, &nonce); err != nil {
+		return err
+	}
+	var ignored bool
+	return s.Deregister(signNonce(t, key, nonce), &ignored)
+}
+
+func testAddr(t *testing.T, address string) net.Addr {
+	addr, err := net.ResolveTCPAddr("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return addr
+}
+
+// Addresses of the registered miners by key.
+func registered() map[string]string {
+	allMiners.RLock()
+	defer allMiners.RUnlock()
+	addrs := make(map[string]string)
+	for k, miner := range allMiners.all {
+		addrs[k] = miner.Address.String()
+	}
+	return addrs
+}
+
+func TestRegisterAgain(t *testing.T) {
+	resetServer(t)
+	s := new(RServer)
+	key, other := testKey(t), testKey(t)
+	var settings MinerNetSettings
+	if err := s.Register(MinerInfo{testAddr(t, "127.0.0.1:9001"), key}, &settings); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(MinerInfo{testAddr(t, "127.0.0.1:9001"), key}, &settings); err != nil {
+		t.Error("Expected the same key to register again from the same address, got: ", err)
+	}
+	if err := s.Register(MinerInfo{testAddr(t, "127.0.0.1:9002"), key}, &settings); err != nil {
+		t.Error("Expected the same key to register again from a new address, got: ", err)
+	}
+	if addrs := registered(); len(addrs) != 1 || addrs[pubKeyToString(key)] != "127.0.0.1:9002" {
+		t.Error("Expected one registration at the new address, got: ", addrs)
+	}
+	err := s.Register(MinerInfo{testAddr(t, "127.0.0.1:9002"), other}, &settings)
+	if err != AddressAlreadyRegisteredError("127.0.0.1:9002") {
+		t.Error("Expected AddressAlreadyRegisteredError for another key, got: ", err)
+	}
+	if err := s.Register(MinerInfo{testAddr(t, "127.0.0.1:9001"), other}, &settings); err != nil {
+		t.Error("Expected the old address of the key to be free, got: ", err)
+	}
+}
+
+func TestRegistrationsSurviveRestart(t *testing.T) {
+	resetServer(t)
+	s := new(RServer)
+	first, second, third := testKey(t), testPrivKey(t), testKey(t)
+	var settings MinerNetSettings
+	for i, key := range []ecdsa.PublicKey{first, second.PublicKey, third} {
+		addr := testAddr(t, []string{"127.0.0.1:9001", "127.0.0.1:9002", "127.0.0.1:9003"}[i])
+		if err := s.Register(MinerInfo{addr, key}, &settings); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := deregister(t, s, second); err != nil {
+		t.Fatal(err)
+	}
+	before := registered()
+
+	// a restart: the server comes up with only its config
+	allMiners.Lock()
+	allMiners.all = make(map[string]*Miner)
+	allMiners.Unlock()
+	if err := loadRegistrations(); err != nil {
+		t.Fatal(err)
+	}
+	after := registered()
+	if len(after) != 2 || after[pubKeyToString(first)] != "127.0.0.1:9001" || after[pubKeyToString(third)] != "127.0.0.1:9003" {
+		t.Error("Expected ", before, " after the restart, got: ", after)
+	}
+	if err := deregister(t, s, second); err != unknownKeyError {
+		t.Error("Expected the deregistered miner to stay unknown, got: ", err)
+	}
+}
+
+func TestLoadRegistrationsFile(t *testing.T) {
+	resetServer(t)
+	if err := loadRegistrations(); err != nil || len(registered()) != 0 {
+		t.Error("Expected no registrations from a missing file, got: ", registered(), err)
+	}
+
+	corrupt := []string{
+		`[{"Key": "0401", "Address": "127.0.0.1:9001"`,
+		`[{"Key": "not hex", "Address": "127.0.0.1:9001"}]`,
+		`[{"Key": "0401", "Address": "no port"}]`,
+	}
+	for _, contents := range corrupt {
+		if err := ioutil.WriteFile(config.RegistrationsFile, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadRegistrations(); err == nil {
+			t.Error("Expected an error for ", contents)
+		}
+		if addrs := registered(); len(addrs) != 0 {
+			t.Error("Expected nothing loaded from ", contents, " got: ", addrs)
+		}
+	}
+}
+
+// Only the miner holding the key can deregister it, and only once per nonce.
+// The calls go over rpc as the keys the server gets are decoded by gob, with
+// the curve sent as its params.
+func TestDeregisterNeedsKey(t *testing.T) {
+	resetServer(t)
+	gob.Register(&net.TCPAddr{})
+	gob.Register(&elliptic.CurveParams{})
+	server := rpc.NewServer()
+	server.Register(new(RServer))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go server.Accept(l)
+	client, err := rpc.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	key, other := testPrivKey(t), testPrivKey(t)
+	key.Curve, other.Curve = elliptic.P256().Params(), elliptic.P256().Params()
+	var settings MinerNetSettings
+	if err := client.Call("RServer.Register", MinerInfo{testAddr(t, "127.0.0.1:9001"), key.PublicKey}, &settings); err != nil {
+		t.Fatal(err)
+	}
+	invalid := InvalidSignatureError("127.0.0.1:9001").Error()
+	var nonce []byte
+	var ignored bool
+	if err := client.Call("RServer.Deregister", signNonce(t, key, []byte("made up")), &ignored); err == nil || err.Error() != invalid {
+		t.Error("Expected ", invalid, " without a nonce, got: ", err)
+	}
+
+	if err := client.Call("RServer.DeregisterNonce", key.PublicKey, &nonce); err != nil {
+		t.Fatal(err)
+	}
+	forged := signNonce(t, other, nonce)
+	forged.Key = key.PublicKey
+	if err := client.Call("RServer.Deregister", forged, &ignored); err == nil || err.Error() != invalid {
+		t.Error("Expected ", invalid, " for a nonce signed by another key, got: ", err)
+	}
+	if err := client.Call("RServer.Deregister", signNonce(t, key, nonce), &ignored); err == nil || err.Error() != invalid {
+		t.Error("Expected ", invalid, " for a nonce used before, got: ", err)
+	}
+	if len(registered()) != 1 {
+		t.Fatal("Expected the miner to stay registered, got: ", registered())
+	}
+
+	if err := client.Call("RServer.DeregisterNonce", key.PublicKey, &nonce); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Call("RServer.Deregister", signNonce(t, key, nonce), &ignored); err != nil {
+		t.Error("Expected the miner deregistered, got: ", err)
+	}
+	if addrs := registered(); len(addrs) != 0 {
+		t.Error("Expected no registrations, got: ", addrs)
+	}
+}
@@ -57,11 +57,6 @@ type Coordinate struct {
 // 	minerPubKey string // miner who "owns" the current pixel on shared canvas
 // }
 
-type M struct {
-	count     int
-	publicKey string
-}
-
 type InkAccount struct {
 	inkMined  uint32
 	inkSpent  uint32
@@ -76,21 +71,22 @@ type Block struct {
 	PubKeyMiner      string
 	Index            int
 	MinerInks        map[string]InkAccount
-	CanvasInks       map[string]M
+	CanvasShapes     map[string]SvgHelper.Shape
 	CanvasOperations map[string][]string // Ink Miner to List of Operations on canvas
 }
 
 func main() {
 
-	mapPoints := make(map[string]SvgHelper.MapPoint)
+	shapes := make(map[string]SvgHelper.Shape)
+	canvas := SvgHelper.CanvasSettings{CanvasXMax: 1024, CanvasYMax: 1024}
 	//add triangle
-	// SvgHelper.AddShapeToMap("M 4 0 L 0 4 h 8 l -4 -4", "123", "fill", 300, mapPoints)
+	// SvgHelper.AddShapeToMap("triangle", "M 4 0 L 0 4 h 8 l -4 -4", "123", "fill", 1, 300, canvas, shapes, nil)
 	// //add square
-	// SvgHelper.AddShapeToMap("M 9 0 l 4 0 v 4 h -4 z", "323", "fill", 300, mapPoints)
+	// SvgHelper.AddShapeToMap("square", "M 9 0 l 4 0 v 4 h -4 z", "323", "fill", 1, 300, canvas, shapes, nil)
 	// add 凹
-	SvgHelper.AddShapeToMap("M 0 0 L 0 5", "123", "fill", 300, mapPoints)
+	SvgHelper.AddShapeToMap("line", "M 0 0 L 0 5", "123", "fill", 1, 300, canvas, shapes, nil)
 	// remove 凹
-	SvgHelper.RemoveShapeFromMap("M 5 0 l 3 0 l 0 3 h 3 v -3  h 3 v 6 h -9 z", "123", "fill", 300, mapPoints)
+	SvgHelper.RemoveShapeFromMap("line", "123", canvas, shapes, nil)
 	// // add 凸
-	SvgHelper.AddShapeToMap("M 5 5 l 3 0 l 0 3 h 3 v 3  h -9 v -3 h 3 z", "143", "fill", 300, mapPoints)
+	SvgHelper.AddShapeToMap("convex", "M 5 5 l 3 0 l 0 3 h 3 v 3  h -9 v -3 h 3 z", "143", "fill", 1, 300, canvas, shapes, nil)
 }